@@ -0,0 +1,107 @@
+package qcache_health
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// AuthMiddleware protects the endpoint with a bearer token ('auth-token') and/or
+// basic-auth ('auth-user' and 'auth-password'), and with a verified client certificate
+// if 'tls-client-ca' is set. Paths listed in 'auth-exempt' (default: /_health/live) are
+// served without credentials.
+func (p *Plugin) AuthMiddleware(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	token := p.CfgStringOr("auth-token", "")
+	user := p.CfgStringOr("auth-user", "")
+	passwd := p.CfgStringOr("auth-password", "")
+	mTLS := p.clientCertRequired()
+	if token == "" && user == "" && !mTLS {
+		next(rw, r)
+		return
+	}
+	for _, path := range strings.Split(p.CfgStringOr("auth-exempt", "/_health/live"), ",") {
+		if path != "" && r.URL.Path == path {
+			next(rw, r)
+			return
+		}
+	}
+	if mTLS && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+		p.Log("debug", fmt.Sprintf("Request to %s from %s without client certificate", r.URL.Path, r.RemoteAddr))
+		http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if token == "" && user == "" {
+		next(rw, r)
+		return
+	}
+	if token != "" {
+		auth := r.Header.Get("Authorization")
+		if strings.HasPrefix(auth, "Bearer ") && secureCompare(strings.TrimPrefix(auth, "Bearer "), token) {
+			next(rw, r)
+			return
+		}
+	}
+	if user != "" {
+		u, pw, ok := r.BasicAuth()
+		if ok && secureCompare(u, user) && secureCompare(pw, passwd) {
+			next(rw, r)
+			return
+		}
+		rw.Header().Set("WWW-Authenticate", `Basic realm="health"`)
+	} else {
+		rw.Header().Set("WWW-Authenticate", `Bearer realm="health"`)
+	}
+	p.Log("debug", fmt.Sprintf("Unauthorized request to %s from %s", r.URL.Path, r.RemoteAddr))
+	http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+func secureCompare(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// clientCertRequired reports whether clients have to present a certificate, which only
+// applies to the paths not exempt from authentication.
+func (p *Plugin) clientCertRequired() bool {
+	return p.CfgStringOr("tls-cert", "") != "" && p.CfgStringOr("tls-client-ca", "") != ""
+}
+
+// tlsConfig returns nil if no 'tls-cert' is configured. With 'tls-client-ca' set, a client
+// certificate is verified against the given CA if presented; AuthMiddleware requires it
+// for the paths not listed in 'auth-exempt', so that probes can reach those without one.
+func (p *Plugin) tlsConfig() (cfg *tls.Config, err error) {
+	cert := p.CfgStringOr("tls-cert", "")
+	key := p.CfgStringOr("tls-key", "")
+	if cert == "" && key == "" {
+		return
+	}
+	if cert == "" || key == "" {
+		return nil, fmt.Errorf("Both tls-cert and tls-key have to be set")
+	}
+	crt, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, fmt.Errorf("Could not load key pair '%s'/'%s': %v", cert, key, err)
+	}
+	cfg = &tls.Config{
+		Certificates: []tls.Certificate{crt},
+		MinVersion: tls.VersionTLS12,
+	}
+	clientCA := p.CfgStringOr("tls-client-ca", "")
+	if clientCA == "" {
+		return
+	}
+	pem, err := ioutil.ReadFile(clientCA)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read client CA '%s': %v", clientCA, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("Could not parse any certificate from client CA '%s'", clientCA)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	return
+}
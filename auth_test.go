@@ -0,0 +1,132 @@
+package qcache_health
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+	"github.com/zpatrick/go-config"
+	"github.com/qframe/types/qchannel"
+)
+
+func newCfgPlugin(t *testing.T, cfgMap map[string]string) Plugin {
+	cfg := config.NewConfig([]config.Provider{config.NewStatic(cfgMap)})
+	p, err := New(qtypes_qchannel.NewQChan(), cfg, "test")
+	assert.NoError(t, err)
+	return p
+}
+
+func authRequest(p Plugin, path string, prep func(r *http.Request)) int {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", path, nil)
+	if prep != nil {
+		prep(req)
+	}
+	p.AuthMiddleware(rec, req, func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+	return rec.Code
+}
+
+func TestPlugin_AuthMiddleware(t *testing.T) {
+	p := newCfgPlugin(t, map[string]string{})
+	assert.Equal(t, http.StatusOK, authRequest(p, "/_health", nil), "No auth configured")
+	p = newCfgPlugin(t, map[string]string{
+		"cache.test.auth-token": "s3cret",
+		"cache.test.auth-user": "admin",
+		"cache.test.auth-password": "pw",
+	})
+	assert.Equal(t, http.StatusUnauthorized, authRequest(p, "/_health", nil))
+	assert.Equal(t, http.StatusOK, authRequest(p, "/_health/live", nil), "Exempt by default")
+	assert.Equal(t, http.StatusOK, authRequest(p, "/_health", func(r *http.Request) {
+		r.Header.Set("Authorization", "Bearer s3cret")
+	}))
+	assert.Equal(t, http.StatusUnauthorized, authRequest(p, "/_health", func(r *http.Request) {
+		r.Header.Set("Authorization", "Bearer wrong")
+	}))
+	assert.Equal(t, http.StatusOK, authRequest(p, "/_health", func(r *http.Request) {
+		r.SetBasicAuth("admin", "pw")
+	}))
+	assert.Equal(t, http.StatusUnauthorized, authRequest(p, "/_health", func(r *http.Request) {
+		r.SetBasicAuth("admin", "wrong")
+	}))
+	p = newCfgPlugin(t, map[string]string{
+		"cache.test.auth-token": "s3cret",
+		"cache.test.auth-exempt": "",
+	})
+	assert.Equal(t, http.StatusUnauthorized, authRequest(p, "/_health/live", nil))
+}
+
+func writeTestCert(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{CommonName: "localhost"},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(time.Hour),
+		IsCA: true,
+		BasicConstraintsValid: true,
+		KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return
+}
+
+func TestPlugin_tlsConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache-health")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cert, key := writeTestCert(t, dir)
+	p := newCfgPlugin(t, map[string]string{})
+	cfg, err := p.tlsConfig()
+	assert.NoError(t, err)
+	assert.Nil(t, cfg, "Plain HTTP without tls-cert")
+	p = newCfgPlugin(t, map[string]string{"cache.test.tls-cert": cert})
+	_, err = p.tlsConfig()
+	assert.Error(t, err, "tls-key is missing")
+	p = newCfgPlugin(t, map[string]string{"cache.test.tls-cert": cert, "cache.test.tls-key": key})
+	cfg, err = p.tlsConfig()
+	assert.NoError(t, err)
+	assert.Len(t, cfg.Certificates, 1)
+	assert.Equal(t, tls.NoClientCert, cfg.ClientAuth)
+	p = newCfgPlugin(t, map[string]string{
+		"cache.test.tls-cert": cert,
+		"cache.test.tls-key": key,
+		"cache.test.tls-client-ca": cert,
+	})
+	cfg, err = p.tlsConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, tls.VerifyClientCertIfGiven, cfg.ClientAuth)
+	assert.Equal(t, http.StatusOK, authRequest(p, "/_health/live", nil), "Probes need no client certificate")
+	assert.Equal(t, http.StatusUnauthorized, authRequest(p, "/_health", nil))
+	assert.Equal(t, http.StatusOK, authRequest(p, "/_health", func(r *http.Request) {
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{}}}
+	}))
+	p = newCfgPlugin(t, map[string]string{
+		"cache.test.tls-cert": cert,
+		"cache.test.tls-key": key,
+		"cache.test.tls-client-ca": key,
+	})
+	_, err = p.tlsConfig()
+	assert.Error(t, err, "Key is no certificate")
+}
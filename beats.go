@@ -0,0 +1,58 @@
+package qcache_health
+
+import (
+	"sort"
+)
+
+// RegisterRoutineType adds a set of routines for the type, returns false if it already exists.
+func (he *HealthEndpoint) RegisterRoutineType(routineType string) bool {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	if _, ok := he.goRoutines[routineType]; ok {
+		return false
+	}
+	he.goRoutines[routineType] = NewRoutines()
+	return true
+}
+
+// HasRoutineType reports whether routines of the type are tracked.
+func (he *HealthEndpoint) HasRoutineType(routineType string) bool {
+	he.mu.RLock()
+	defer he.mu.RUnlock()
+	_, ok := he.goRoutines[routineType]
+	return ok
+}
+
+// RoutineTypes returns the tracked routine types in alphabetical order.
+func (he *HealthEndpoint) RoutineTypes() []string {
+	he.mu.RLock()
+	defer he.mu.RUnlock()
+	res := []string{}
+	for k := range he.goRoutines {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// RejectBeat counts a HealthBeat of the given type, which was dropped.
+func (he *HealthEndpoint) RejectBeat(beatType string) {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	he.rejectedBeats[beatType]++
+}
+
+// GetRejectedBeats returns the number of dropped HealthBeats per beat type.
+func (he *HealthEndpoint) GetRejectedBeats() map[string]int {
+	he.mu.RLock()
+	defer he.mu.RUnlock()
+	return he.getRejectedBeats()
+}
+
+func (he *HealthEndpoint) getRejectedBeats() map[string]int {
+	res := map[string]int{}
+	for k, v := range he.rejectedBeats {
+		res[k] = v
+	}
+	return res
+}
@@ -0,0 +1,28 @@
+package qcache_health
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthEndpoint_RegisterRoutineType(t *testing.T) {
+	he := NewHealthEndpoint([]string{"log"})
+	assert.False(t, he.HasRoutineType("journald"))
+	assert.Error(t, he.AddRoutine("journald", rt1))
+	assert.True(t, he.RegisterRoutineType("journald"))
+	assert.False(t, he.RegisterRoutineType("journald"), "Already registered")
+	assert.NoError(t, he.AddRoutine("journald", rt1))
+	assert.Equal(t, 1, he.CountRoutine("journald"))
+	assert.Equal(t, []string{"journald", "log"}, he.RoutineTypes())
+}
+
+func TestHealthEndpoint_RejectBeat(t *testing.T) {
+	he := NewHealthEndpoint([]string{"log"})
+	assert.NotContains(t, he.GetJSON(), "rejected_beats")
+	he.RejectBeat("routine.x")
+	he.RejectBeat("routine.x")
+	he.RejectBeat("foo")
+	exp := map[string]int{"routine.x": 2, "foo": 1}
+	assert.Equal(t, exp, he.GetRejectedBeats())
+	assert.Equal(t, exp, he.GetJSON()["rejected_beats"])
+}
@@ -0,0 +1,186 @@
+package qcache_health
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CheckResult is the outcome of a single Checker.
+type CheckResult struct {
+	Status 	string
+	Message string
+	Details map[string]interface{}
+}
+
+func (cr CheckResult) GetJSON() map[string]interface{} {
+	return map[string]interface{}{
+		"status": cr.Status,
+		"message": cr.Message,
+		"details": cr.Details,
+	}
+}
+
+// Checker evaluates one health rule. Check must not hold on to the HealthEndpoint,
+// it is only passed to read the current state (e.g. CountRoutine()).
+type Checker interface {
+	Name() string
+	Check(he *HealthEndpoint) CheckResult
+}
+
+type checkerFunc struct {
+	name 	string
+	fn 		func(he *HealthEndpoint) CheckResult
+}
+
+// NewCheckerFunc wraps a function as Checker.
+func NewCheckerFunc(name string, fn func(he *HealthEndpoint) CheckResult) Checker {
+	return checkerFunc{name: name, fn: fn}
+}
+
+func (c checkerFunc) Name() string {
+	return c.name
+}
+
+func (c checkerFunc) Check(he *HealthEndpoint) CheckResult {
+	return c.fn(he)
+}
+
+// RegisterChecker adds a checker, which is evaluated after the ones already registered.
+func (he *HealthEndpoint) RegisterChecker(c Checker) (err error) {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	for _, x := range he.checkers {
+		if x.Name() == c.Name() {
+			return fmt.Errorf("Checker '%s' already registered", c.Name())
+		}
+	}
+	he.checkers = append(he.checkers, c)
+	return
+}
+
+// Checkers returns the names of the registered checkers in evaluation order.
+func (he *HealthEndpoint) Checkers() []string {
+	he.mu.RLock()
+	defer he.mu.RUnlock()
+	res := []string{}
+	for _, c := range he.checkers {
+		res = append(res, c.Name())
+	}
+	return res
+}
+
+// Evaluate runs all checkers and returns the worst status with a message composed of the prefix
+// and the messages of all checkers up to the first unhealthy one. All results are kept for GetJSON().
+func (he *HealthEndpoint) Evaluate(prefix []string) (status, msg string) {
+	he.mu.RLock()
+	checkers := append([]Checker{}, he.checkers...)
+	he.mu.RUnlock()
+	results := map[string]CheckResult{}
+	status = Healthy
+	msgs := append([]string{}, prefix...)
+	for _, c := range checkers {
+		res := c.Check(he)
+		results[c.Name()] = res
+		if status != Unhealthy && res.Message != "" {
+			msgs = append(msgs, res.Message)
+		}
+		status = WorseHealth(status, res.Status)
+	}
+	he.mu.Lock()
+	he.checkResults = results
+	he.mu.Unlock()
+	return status, strings.Join(msgs, " | ")
+}
+
+// GetCheckResult returns the result of the named checker from the last Evaluate().
+func (he *HealthEndpoint) GetCheckResult(name string) (CheckResult, bool) {
+	he.mu.RLock()
+	defer he.mu.RUnlock()
+	res, ok := he.checkResults[name]
+	return res, ok
+}
+
+func (he *HealthEndpoint) getChecksJSON() map[string]interface{} {
+	res := map[string]interface{}{}
+	for n, cr := range he.checkResults {
+		res[n] = cr.GetJSON()
+	}
+	return res
+}
+
+// NewStatsChecker expects a stats routine for every running container, except the pending ones.
+// With unknown running containers (-1) it leaves the verdict to the docker checker.
+func NewStatsChecker() Checker {
+	return NewCheckerFunc("stats", func(he *HealthEndpoint) CheckResult {
+		cntCount := he.GetRunningContainers()
+		statsCnt := he.CountRoutine("stats")
+		pending := he.PendingCount("stats")
+		res := CheckResult{
+			Status: Healthy,
+			Message: fmt.Sprintf("metricsGoRoutines:%d", statsCnt),
+			Details: map[string]interface{}{"containers": cntCount, "stats": statsCnt, "pending": pending},
+		}
+		if cntCount >= 0 && cntCount-pending != statsCnt {
+			res.Status = Unhealthy
+			res.Message = explain(res.Message, he.DiscrepancySummary("stats"))
+		}
+		return res
+	})
+}
+
+// NewLogsChecker expects a log, logSkip or logWrongType routine for every running container, except the pending ones.
+// Containers with a log driver other than json-file (logWrongType) are covered, but degrade the status.
+func NewLogsChecker() Checker {
+	return NewCheckerFunc("logs", func(he *HealthEndpoint) CheckResult {
+		cntCount := he.GetRunningContainers()
+		lCnt := he.CountRoutine("log")
+		lSkipCnt := he.CountRoutine("logSkip")
+		lWrongType := he.CountRoutine("logWrongType")
+		pending := he.PendingCount("logs")
+		res := CheckResult{
+			Status: Healthy,
+			Message: fmt.Sprintf("logsGoRoutine:(%d [logs] + %d [skipped] + %d [non json-file])", lCnt, lSkipCnt, lWrongType),
+			Details: map[string]interface{}{"containers": cntCount, "log": lCnt, "logSkip": lSkipCnt, "logWrongType": lWrongType, "pending": pending},
+		}
+		if cntCount >= 0 && cntCount-pending != (lCnt + lSkipCnt + lWrongType) {
+			res.Status = Unhealthy
+			res.Message = explain(res.Message, he.DiscrepancySummary("logs"))
+		} else if lWrongType > 0 {
+			res.Status = Degraded
+		}
+		return res
+	})
+}
+
+// NewVitalsChecker reports vitals exceeding the ages set by the VitalPolicies.
+func NewVitalsChecker() Checker {
+	return NewCheckerFunc("vitals", func(he *HealthEndpoint) CheckResult {
+		stale := he.GetStaleVitals(time.Now())
+		res := CheckResult{
+			Status: Healthy,
+			Details: map[string]interface{}{"stale": stale},
+		}
+		msgs := []string{}
+		for _, l := range []string{Unhealthy, vitalWarn} {
+			if len(stale[l]) > 0 {
+				msgs = append(msgs, fmt.Sprintf("vitals %s:[%s]", l, strings.Join(stale[l], ",")))
+			}
+		}
+		res.Message = strings.Join(msgs, " | ")
+		if len(stale[Unhealthy]) > 0 {
+			res.Status = Unhealthy
+		} else if len(stale[vitalWarn]) > 0 {
+			res.Status = Degraded
+		}
+		return res
+	})
+}
+
+// explain appends the container-level discrepancy of a failing routine group to the message.
+func explain(msg, discrepancy string) string {
+	if discrepancy == "" {
+		return msg
+	}
+	return strings.Join([]string{msg, discrepancy}, " | ")
+}
@@ -0,0 +1,53 @@
+package qcache_health
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthEndpoint_RegisterChecker(t *testing.T) {
+	he := NewHealthEndpoint([]string{"stats"})
+	assert.NoError(t, he.RegisterChecker(NewStatsChecker()))
+	assert.Error(t, he.RegisterChecker(NewStatsChecker()), "Name already taken")
+	queue := 0
+	err := he.RegisterChecker(NewCheckerFunc("queue", func(he *HealthEndpoint) CheckResult {
+		if queue > 10 {
+			return CheckResult{Status: Unhealthy, Message: "queue:full", Details: map[string]interface{}{"len": queue}}
+		}
+		return CheckResult{Status: Healthy, Message: "queue:ok", Details: map[string]interface{}{"len": queue}}
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"stats", "queue"}, he.Checkers())
+}
+
+func TestHealthEndpoint_Evaluate(t *testing.T) {
+	he := NewHealthEndpoint([]string{"stats"})
+	he.RegisterChecker(NewStatsChecker())
+	he.RegisterChecker(NewCheckerFunc("custom", func(he *HealthEndpoint) CheckResult {
+		return CheckResult{Status: Healthy, Message: "custom:ok"}
+	}))
+	he.SetRunningContainers(1)
+	s, m := he.Evaluate([]string{"RunningContainers:1"})
+	assert.Equal(t, Unhealthy, s)
+	assert.Equal(t, "RunningContainers:1 | metricsGoRoutines:0", m, "Messages stop at the first failing checker")
+	checks := he.GetJSON()["checks"].(map[string]interface{})
+	assert.Equal(t, Healthy, checks["custom"].(map[string]interface{})["status"], "But all checkers are evaluated")
+	he.AddRoutine("stats", rt1)
+	s, m = he.Evaluate([]string{})
+	assert.Equal(t, Healthy, s)
+	assert.Equal(t, "metricsGoRoutines:1 | custom:ok", m)
+}
+
+func TestLogsChecker_Degraded(t *testing.T) {
+	he := NewHealthEndpoint([]string{"log", "logSkip", "logWrongType"})
+	he.SetRunningContainers(2)
+	he.AddRoutine("log", rt1)
+	he.AddRoutine("logWrongType", rt2)
+	he.RegisterChecker(NewLogsChecker())
+	he.RegisterChecker(NewCheckerFunc("custom", func(he *HealthEndpoint) CheckResult {
+		return CheckResult{Status: Healthy, Message: "custom:ok"}
+	}))
+	s, m := he.Evaluate([]string{})
+	assert.Equal(t, Degraded, s)
+	assert.Equal(t, "logsGoRoutine:(1 [logs] + 0 [skipped] + 1 [non json-file]) | custom:ok", m)
+}
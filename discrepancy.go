@@ -0,0 +1,138 @@
+package qcache_health
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Discrepancy lists the differences between the running containers and a group of
+// routine types, that are expected to cover every container together.
+// Containers without routine, which are still within the grace period, are Pending instead of Missing.
+type Discrepancy struct {
+	Missing 	[]string
+	Orphaned 	[]string
+	Pending 	[]string
+}
+
+func (d Discrepancy) Empty() bool {
+	return len(d.Missing) == 0 && len(d.Orphaned) == 0
+}
+
+// SetContainers stores the running containers (ID -> name) and their count.
+func (he *HealthEndpoint) SetContainers(cnts map[string]string) {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	he.containers = map[string]string{}
+	for id, name := range cnts {
+		he.containers[shortID(id)] = name
+	}
+	he.cntCount = len(cnts)
+	he.pruneContainerHealth()
+}
+
+// SetRoutineGroups sets which routine types have to cover the running containers together.
+func (he *HealthEndpoint) SetRoutineGroups(groups map[string][]string) {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	he.groups = groups
+}
+
+// GetDiscrepancies computes the discrepancy for each routine group, nil if the running containers are unknown.
+func (he *HealthEndpoint) GetDiscrepancies() map[string]Discrepancy {
+	he.mu.RLock()
+	defer he.mu.RUnlock()
+	return he.getDiscrepancies(time.Now())
+}
+
+func (he *HealthEndpoint) getDiscrepancies(t time.Time) map[string]Discrepancy {
+	if he.containers == nil {
+		return nil
+	}
+	res := map[string]Discrepancy{}
+	for group, typs := range he.groups {
+		d := Discrepancy{Missing: []string{}, Orphaned: []string{}, Pending: []string{}}
+		covered := map[string]bool{}
+		for _, typ := range typs {
+			for _, id := range he.activeRoutines(typ, t) {
+				covered[id] = true
+				if _, ok := he.containers[id]; !ok {
+					d.Orphaned = append(d.Orphaned, fmt.Sprintf("%s:%s", typ, id))
+				}
+			}
+		}
+		for id := range he.containers {
+			if covered[id] {
+				continue
+			}
+			if he.isPending(id, t) {
+				d.Pending = append(d.Pending, he.containerRef(id))
+			} else {
+				d.Missing = append(d.Missing, he.containerRef(id))
+			}
+		}
+		sort.Strings(d.Missing)
+		sort.Strings(d.Pending)
+		sort.Strings(d.Orphaned)
+		res[group] = d
+	}
+	return res
+}
+
+// containerRef returns 'name(id)', or only the id if the name is unknown.
+func (he *HealthEndpoint) containerRef(id string) string {
+	if name := he.containers[id]; name != "" {
+		return fmt.Sprintf("%s(%s)", name, id)
+	}
+	return id
+}
+
+// DiscrepancySummary returns a short text for the message of an unhealthy status, empty if there is none.
+func (he *HealthEndpoint) DiscrepancySummary(group string) string {
+	d, ok := he.GetDiscrepancies()[group]
+	if !ok || d.Empty() {
+		return ""
+	}
+	res := []string{}
+	if len(d.Missing) > 0 {
+		res = append(res, fmt.Sprintf("%s without routine:[%s]", group, strings.Join(d.Missing, ",")))
+	}
+	if len(d.Orphaned) > 0 {
+		res = append(res, fmt.Sprintf("%s without container:[%s]", group, strings.Join(d.Orphaned, ",")))
+	}
+	return strings.Join(res, " | ")
+}
+
+func (he *HealthEndpoint) getDiscrepanciesJSON(t time.Time) map[string]interface{} {
+	res := map[string]interface{}{}
+	for group, d := range he.getDiscrepancies(t) {
+		res[group] = map[string]interface{}{
+			"containers_without_routine": d.Missing,
+			"routines_without_container": d.Orphaned,
+			"containers_pending": d.Pending,
+		}
+	}
+	return res
+}
+
+func (he *HealthEndpoint) getDiscrepanciesTXT(t time.Time) []string {
+	res := []string{}
+	ds := he.getDiscrepancies(t)
+	keys := []string{}
+	for k := range ds {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		d := ds[k]
+		if len(d.Pending) > 0 {
+			res = append(res, fmt.Sprintf("%-15s: | pending:%s", "~"+k, strings.Join(d.Pending, ",")))
+		}
+		if d.Empty() {
+			continue
+		}
+		res = append(res, fmt.Sprintf("%-15s: | without routine:%s | without container:%s", "!"+k, strings.Join(d.Missing, ","), strings.Join(d.Orphaned, ",")))
+	}
+	return res
+}
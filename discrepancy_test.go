@@ -0,0 +1,34 @@
+package qcache_health
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"strings"
+)
+
+func TestHealthEndpoint_GetDiscrepancies(t *testing.T) {
+	he := NewHealthEndpoint([]string{"log", "logSkip", "stats"})
+	he.SetRoutineGroups(map[string][]string{"stats": {"stats"}, "logs": {"log", "logSkip"}})
+	assert.Nil(t, he.GetDiscrepancies(), "Containers are not known yet")
+	assert.NotContains(t, he.GetJSON(), "discrepancies")
+	he.SetContainers(map[string]string{"aaaaaaaaaaaa0000": "web", "bbbbbbbbbbbb0000": ""})
+	he.AddRoutine("log", NewRoutine("aaaaaaaaaaaa", "start", ts))
+	he.AddRoutine("logSkip", NewRoutine("bbbbbbbbbbbb", "start", ts))
+	he.AddRoutine("stats", NewRoutine("aaaaaaaaaaaa", "start", ts))
+	he.AddRoutine("stats", NewRoutine("cccccccccccc", "start", ts))
+	exp := map[string]Discrepancy{
+		"logs": {Missing: []string{}, Orphaned: []string{}, Pending: []string{}},
+		"stats": {Missing: []string{"bbbbbbbbbbbb"}, Orphaned: []string{"stats:cccccccccccc"}, Pending: []string{}},
+	}
+	assert.Equal(t, exp, he.GetDiscrepancies())
+	assert.Equal(t, "stats without routine:[bbbbbbbbbbbb] | stats without container:[stats:cccccccccccc]", he.DiscrepancySummary("stats"))
+	assert.Equal(t, "", he.DiscrepancySummary("logs"))
+	assert.Contains(t, he.GetJSON(), "discrepancies")
+	assert.True(t, strings.HasSuffix(he.GetTXT(), "!stats         : | without routine:bbbbbbbbbbbb | without container:stats:cccccccccccc\n"))
+	assert.Equal(t, 2, he.GetRunningContainers())
+	he.SetRunningContainers(-1)
+	assert.Nil(t, he.GetDiscrepancies(), "Containers are unknown again")
+	assert.NotContains(t, he.GetJSON(), "discrepancies")
+	assert.NotContains(t, he.GetJSON(), "pending")
+	assert.NotContains(t, he.GetTXT(), "without routine")
+}
@@ -0,0 +1,101 @@
+package qcache_health
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	dockerUnreachable = "docker-unreachable"
+)
+
+// dockerConn keeps track of the reconnection attempts to the docker engine.
+type dockerConn struct {
+	reachable 	bool
+	backoff 	time.Duration
+	retryAt 	time.Time
+}
+
+// dockerCtx returns a context for a single docker call, limited by 'docker-timeout-ms'.
+func (p *Plugin) dockerCtx() (context.Context, context.CancelFunc) {
+	timeout := time.Duration(p.CfgIntOr("docker-timeout-ms", 5000))*time.Millisecond
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// negotiateDocker pings the engine and, unless 'docker-api-version' pins it, downgrades the
+// API version to the one supported by the engine.
+func (p *Plugin) negotiateDocker(e *dockerEngine) (err error) {
+	c, cancel := p.dockerCtx()
+	defer cancel()
+	ping, err := e.cli.Ping(c)
+	if err != nil {
+		return fmt.Errorf("Ping(): %s", err)
+	}
+	if p.CfgStringOr(e.cfgKey("docker-api-version"), "") == "" {
+		e.cli.NegotiateAPIVersionPing(ping)
+	}
+	p.Log("info", fmt.Sprintf("Connected to docker engine '%s' using API v%s", e.cli.DaemonHost(), e.cli.ClientVersion()))
+	return
+}
+
+// ensureDocker reconnects to an unreachable engine once the backoff passed and reports whether it is reachable.
+func (p *Plugin) ensureDocker(e *dockerEngine, t time.Time) bool {
+	if e.conn.reachable {
+		return true
+	}
+	if t.Before(e.conn.retryAt) {
+		return false
+	}
+	if err := p.connectingDocker(e); err != nil {
+		p.dockerFailed(e, err, t)
+		return false
+	}
+	return true
+}
+
+// dockerConnected resets the backoff after the engine answered.
+func (p *Plugin) dockerConnected(e *dockerEngine) {
+	e.conn = dockerConn{reachable: true}
+	e.resetInventory()
+	p.HealthEndpoint.SetEngineReachable(e.name, e.cli.ClientVersion())
+}
+
+// dockerFailed marks the engine as unreachable and schedules the next connection attempt, doubling the
+// backoff from 'docker-retry-ms' up to 'docker-retry-max-ms'. Once no engine is reachable, the running
+// containers are unknown; the status is left to the docker checker.
+func (p *Plugin) dockerFailed(e *dockerEngine, err error, t time.Time) {
+	minBackoff := time.Duration(p.CfgIntOr("docker-retry-ms", 1000))*time.Millisecond
+	maxBackoff := time.Duration(p.CfgIntOr("docker-retry-max-ms", 60000))*time.Millisecond
+	switch {
+	case e.conn.backoff < minBackoff:
+		e.conn.backoff = minBackoff
+	case e.conn.backoff*2 > maxBackoff:
+		e.conn.backoff = maxBackoff
+	default:
+		e.conn.backoff *= 2
+	}
+	e.conn.reachable = false
+	e.conn.retryAt = t.Add(e.conn.backoff)
+	msg := fmt.Sprintf("%s: %s", dockerUnreachable, err)
+	if e.name != "" {
+		msg = fmt.Sprintf("%s: %s: %s", dockerUnreachable, e.name, err)
+	}
+	p.Log("error", fmt.Sprintf("%s (retry in %s)", msg, e.conn.backoff))
+	p.HealthEndpoint.SetEngineUnreachable(e.name, err.Error(), t)
+	if p.reachableEngines() == 0 {
+		p.HealthEndpoint.SetRunningContainers(-1)
+	}
+}
+
+// IsDockerReachable reports whether the last call to every engine succeeded.
+func (he *HealthEndpoint) IsDockerReachable() bool {
+	he.mu.RLock()
+	defer he.mu.RUnlock()
+	for _, es := range he.engines {
+		if es.err != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+package qcache_health
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/docker/docker/api/types"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+func TestPlugin_dockerFailed(t *testing.T) {
+	p := newCfgPlugin(t, map[string]string{
+		"log.level": "error",
+		"cache.test.docker-retry-ms": "100",
+		"cache.test.docker-retry-max-ms": "250",
+	})
+	p.SetHealth(Healthy, "Start")
+	now := time.Now()
+	for _, exp := range []time.Duration{100, 200, 250, 250} {
+		p.dockerFailed(p.engines[0], fmt.Errorf("Ping(): connection refused"), now)
+		assert.Equal(t, exp*time.Millisecond, p.engines[0].conn.backoff)
+	}
+	assert.Equal(t, now.Add(250*time.Millisecond), p.engines[0].conn.retryAt)
+	assert.False(t, p.HealthEndpoint.IsDockerReachable())
+	assert.Equal(t, -1, p.HealthEndpoint.GetRunningContainers())
+	s, _ := p.HealthEndpoint.CurrentHealth()
+	assert.Equal(t, Healthy, s, "The status is left to the docker checker")
+	p.checkHealth(-1)
+	s, m := p.HealthEndpoint.CurrentHealth()
+	assert.Equal(t, Unhealthy, s)
+	assert.Equal(t, "docker-unreachable: Ping(): connection refused", m)
+	dj := p.HealthEndpoint.GetJSON()["docker"].(map[string]interface{})
+	assert.Equal(t, false, dj["reachable"])
+	assert.Equal(t, now.Format(time.RFC3339Nano), dj["since"], "Since the first failure")
+	assert.Contains(t, p.HealthEndpoint.GetTXT(), "\ndocker-unreachable:Ping(): connection refused | since:")
+	assert.False(t, p.ensureDocker(p.engines[0], now.Add(100*time.Millisecond)), "Backoff not yet passed")
+}
+
+func TestPlugin_ensureDocker(t *testing.T) {
+	srv := fakeDocker(t, []types.Container{})
+	defer srv.Close()
+	p := newCfgPlugin(t, map[string]string{
+		"log.level": "error",
+		"cache.test.docker-host": strings.Replace(srv.URL, "http://", "tcp://", 1),
+	})
+	now := time.Now()
+	p.dockerFailed(p.engines[0], fmt.Errorf("Ping(): connection refused"), now)
+	assert.True(t, p.ensureDocker(p.engines[0], now.Add(time.Second)))
+	assert.True(t, p.HealthEndpoint.IsDockerReachable())
+	assert.Equal(t, time.Duration(0), p.engines[0].conn.backoff)
+	assert.Equal(t, "1.29", p.engines[0].cli.ClientVersion(), "Negotiated with the engine")
+	assert.Equal(t, map[string]interface{}{"reachable": true, "api_version": "1.29"}, p.HealthEndpoint.GetJSON()["docker"])
+	assert.Equal(t, 0, p.getRunningCntCount())
+	srv.Close()
+	assert.Equal(t, -1, p.getRunningCntCount())
+	assert.False(t, p.HealthEndpoint.IsDockerReachable())
+	assert.False(t, p.ensureDocker(p.engines[0], time.Now()))
+}
+
+func TestPlugin_connectingDockerPinned(t *testing.T) {
+	srv := fakeDocker(t, []types.Container{})
+	defer srv.Close()
+	p := newCfgPlugin(t, map[string]string{
+		"log.level": "error",
+		"cache.test.docker-host": strings.Replace(srv.URL, "http://", "tcp://", 1),
+		"cache.test.docker-api-version": "1.25",
+	})
+	assert.NoError(t, p.connectingDocker(p.engines[0]))
+	assert.Equal(t, "1.25", p.engines[0].cli.ClientVersion())
+}
+
+func TestPlugin_dockerTimeout(t *testing.T) {
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fakePing(w, r) {
+			return
+		}
+		<-block
+	}))
+	defer srv.Close()
+	defer close(block)
+	p := newDockerPlugin(t, srv, map[string]string{"log.level": "error", "cache.test.docker-timeout-ms": "50"})
+	p.SetHealth(Healthy, "Start")
+	start := time.Now()
+	assert.Equal(t, -1, p.getRunningCntCount())
+	assert.True(t, time.Since(start) < time.Second, "A hung engine does not block the caller")
+	p.checkHealth(-1)
+	_, m := p.HealthEndpoint.CurrentHealth()
+	assert.True(t, strings.HasPrefix(m, "docker-unreachable: ContainerList(): "), m)
+}
+
+func TestPlugin_checkHealthUnreachable(t *testing.T) {
+	p := newCfgPlugin(t, map[string]string{"log.level": "error", "cache.test.vitals.collector.warn-age-ms": "1000"})
+	p.SetHealth(Healthy, "Start")
+	p.dockerFailed(p.engines[0], fmt.Errorf("Ping(): connection refused"), time.Now())
+	p.HealthEndpoint.UpsertVitals("collector", "ok", time.Now().Add(-time.Minute))
+	p.checkHealth(p.getRunningCntCount())
+	s, m := p.HealthEndpoint.CurrentHealth()
+	assert.Equal(t, Unhealthy, s)
+	assert.Equal(t, "docker-unreachable: Ping(): connection refused", m)
+	assert.Len(t, p.HealthEndpoint.GetHistory(time.Time{}, 0), 3, "One evaluation per tick")
+	checks := p.HealthEndpoint.GetJSON()["checks"].(map[string]interface{})
+	assert.Equal(t, Healthy, checks["stats"].(map[string]interface{})["status"], "Unknown containers are left to the docker check")
+	assert.Equal(t, Degraded, checks["vitals"].(map[string]interface{})["status"], "Still evaluated while docker is unreachable")
+}
@@ -0,0 +1,117 @@
+package qcache_health
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	dockerHealthStarting = "starting"
+	dockerHealthHealthy = "healthy"
+	dockerHealthUnhealthy = "unhealthy"
+)
+
+// ContainerHealth is the status of the HEALTHCHECK of a container, as reported by the docker engine.
+type ContainerHealth struct {
+	Name 	string
+	Status 	string
+	Time 	time.Time
+}
+
+func (ch ContainerHealth) GetJSON() map[string]interface{} {
+	return map[string]interface{}{
+		"name": ch.Name,
+		"status": ch.Status,
+		"time": ch.Time.Format(time.RFC3339Nano),
+	}
+}
+
+// SetContainerHealth stores the HEALTHCHECK status of a container.
+func (he *HealthEndpoint) SetContainerHealth(id, name, status string, t time.Time) {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	id = shortID(id)
+	prev, ok := he.cntHealth[id]
+	he.cntHealth[id] = ContainerHealth{Name: name, Status: status, Time: t}
+	if !ok || prev.Status != status {
+		he.events.Publish("container_health", t, map[string]interface{}{
+			"id": id,
+			"name": name,
+			"status": status,
+		})
+	}
+}
+
+// DelContainerHealth forgets the HEALTHCHECK status of a container, e.g. once it died.
+func (he *HealthEndpoint) DelContainerHealth(id string) {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	delete(he.cntHealth, shortID(id))
+}
+
+// GetContainerHealth returns the HEALTHCHECK status per container ID.
+func (he *HealthEndpoint) GetContainerHealth() map[string]ContainerHealth {
+	he.mu.RLock()
+	defer he.mu.RUnlock()
+	res := map[string]ContainerHealth{}
+	for id, ch := range he.cntHealth {
+		res[id] = ch
+	}
+	return res
+}
+
+// pruneContainerHealth drops the status of containers, which are not running anymore.
+func (he *HealthEndpoint) pruneContainerHealth() {
+	for id := range he.cntHealth {
+		if _, ok := he.containers[id]; !ok {
+			delete(he.cntHealth, id)
+		}
+	}
+}
+
+func (he *HealthEndpoint) getContainerHealthJSON() map[string]interface{} {
+	res := map[string]interface{}{}
+	for id, ch := range he.cntHealth {
+		res[id] = ch.GetJSON()
+	}
+	return res
+}
+
+// containersByHealth returns the containers with the given HEALTHCHECK status as 'name(id)'.
+func (he *HealthEndpoint) containersByHealth(status string) []string {
+	res := []string{}
+	for id, ch := range he.cntHealth {
+		if ch.Status != status {
+			continue
+		}
+		if ch.Name != "" {
+			res = append(res, fmt.Sprintf("%s(%s)", ch.Name, id))
+		} else {
+			res = append(res, id)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// NewContainerHealthChecker sets the status to severity, once at least threshold containers
+// report an unhealthy HEALTHCHECK.
+func NewContainerHealthChecker(threshold int, severity string) Checker {
+	return NewCheckerFunc("container_health", func(he *HealthEndpoint) CheckResult {
+		he.mu.RLock()
+		unhealthy := he.containersByHealth(dockerHealthUnhealthy)
+		starting := he.containersByHealth(dockerHealthStarting)
+		he.mu.RUnlock()
+		res := CheckResult{
+			Status: Healthy,
+			Details: map[string]interface{}{"unhealthy": unhealthy, "starting": starting, "threshold": threshold},
+		}
+		if len(unhealthy) >= threshold {
+			res.Status = severity
+			res.Message = fmt.Sprintf("%d containers unhealthy:[%s]", len(unhealthy), strings.Join(unhealthy, ","))
+		}
+		return res
+	})
+}
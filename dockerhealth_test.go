@@ -0,0 +1,44 @@
+package qcache_health
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"time"
+)
+
+func TestHealthEndpoint_ContainerHealth(t *testing.T) {
+	he := NewHealthEndpoint([]string{})
+	assert.NotContains(t, he.GetJSON(), "container_health")
+	_, _, c := he.Events().Subscribe("", 0)
+	defer he.Events().Unsubscribe(c)
+	he.SetContainerHealth("aaaaaaaaaaaa0000", "web", dockerHealthStarting, ts)
+	he.SetContainerHealth("aaaaaaaaaaaa0000", "web", dockerHealthStarting, ts.Add(time.Second))
+	he.SetContainerHealth("aaaaaaaaaaaa0000", "web", dockerHealthHealthy, ts.Add(2*time.Second))
+	e := <-c
+	assert.Equal(t, "container_health", e.Type)
+	assert.Equal(t, dockerHealthStarting, e.Data["status"])
+	e = <-c
+	assert.Equal(t, dockerHealthHealthy, e.Data["status"], "Only changes are published")
+	exp := map[string]ContainerHealth{"aaaaaaaaaaaa": {Name: "web", Status: dockerHealthHealthy, Time: ts.Add(2 * time.Second)}}
+	assert.Equal(t, exp, he.GetContainerHealth())
+	assert.Contains(t, he.GetJSON(), "container_health")
+	he.SetContainerHealth("bbbbbbbbbbbb0000", "db", dockerHealthUnhealthy, ts)
+	he.SetContainers(map[string]string{"bbbbbbbbbbbb0000": "db"})
+	assert.Len(t, he.GetContainerHealth(), 1, "Containers not running anymore are pruned")
+	he.DelContainerHealth("bbbbbbbbbbbb")
+	assert.Len(t, he.GetContainerHealth(), 0)
+}
+
+func TestContainerHealthChecker(t *testing.T) {
+	he := NewHealthEndpoint([]string{})
+	c := NewContainerHealthChecker(2, Degraded)
+	he.SetContainerHealth("aaaaaaaaaaaa", "web", dockerHealthUnhealthy, ts)
+	he.SetContainerHealth("bbbbbbbbbbbb", "", dockerHealthStarting, ts)
+	res := c.Check(he)
+	assert.Equal(t, Healthy, res.Status)
+	assert.Equal(t, "", res.Message)
+	he.SetContainerHealth("bbbbbbbbbbbb", "", dockerHealthUnhealthy, ts)
+	res = c.Check(he)
+	assert.Equal(t, Degraded, res.Status)
+	assert.Equal(t, "2 containers unhealthy:[bbbbbbbbbbbb,web(aaaaaaaaaaaa)]", res.Message)
+}
@@ -6,66 +6,113 @@ import (
 	"encoding/json"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 	gring "github.com/zfjagann/golang-ring"
-	"github.com/docker/docker/client"
 )
 
 const (
 	ringCapacity = 3
 	Healthy = "healthy"
+	Degraded = "degraded"
 	Unhealthy = "unhealthy"
+	Starting = "starting"
+	defaultLiveTimeout = 10 * time.Second
 )
 
 type HealthEndpoint struct {
+	mu 				sync.RWMutex
 	healthRing 		*gring.Ring
-	healthMsgRing 	*gring.Ring
-	engCli 			client.Client
+	// recent keeps the last ringCapacity evaluations, as healthRing collapses repeated ones
+	recent			[]HealthEntry
 	goRoutines 		map[string]*Routines	`json:"routines,omitempty"`
 	vitals			map[string]*Vitals		`json:"vitals,omitempty"`
-
+	cntCount		int
+	lastTick		time.Time
+	liveTimeout		time.Duration
+	unhealthyCode	int
+	degradedCode	int
+	events			*EventBroker
+	lastReconcile	*ReconcileReport
+	containers		map[string]string
+	groups			map[string][]string
+	checkers		[]Checker
+	checkResults	map[string]CheckResult
+	vitalPolicies	[]VitalPolicy
+	policy			TransitionPolicy
+	evals			[]string
+	changedAt		time.Time
+	gracePeriod		time.Duration
+	started			map[string]time.Time
+	rejectedBeats	map[string]int
+	maxSilence		map[string]time.Duration
+	invalidTransitions	map[string]int
+	stopped			map[string]map[string]Routine
+	cntHealth		map[string]ContainerHealth
+	services		map[string]ServiceStatus
+	convergeTimeout	time.Duration
+	excluded		map[string]string
+	engines			map[string]engineStatus
 }
 
 func NewHealthEndpoint(routines []string) *HealthEndpoint {
 	r := &gring.Ring{}
-	r.SetCapacity(ringCapacity)
-	msgR := &gring.Ring{}
-	msgR.SetCapacity(ringCapacity)
-	r.Enqueue("starting")
-	msgR.Enqueue("Just started")
+	r.SetCapacity(defaultHistoryCapacity)
+	start := NewHealthEntry(Starting, "Just started", time.Now())
+	r.Enqueue(&start)
 	he := &HealthEndpoint{
 		healthRing: r,
-		healthMsgRing: msgR,
+		recent: []HealthEntry{start},
+		changedAt: time.Now(),
 		goRoutines: map[string]*Routines{},
 		vitals: map[string]*Vitals{},
+		cntCount: -1,
+		liveTimeout: defaultLiveTimeout,
+		unhealthyCode: http.StatusOK,
+		degradedCode: http.StatusOK,
+		events: NewEventBroker(),
+		rejectedBeats: map[string]int{},
+		maxSilence: map[string]time.Duration{},
+		invalidTransitions: map[string]int{},
+		stopped: map[string]map[string]Routine{},
+		cntHealth: map[string]ContainerHealth{},
+		engines: map[string]engineStatus{},
 	}
 	for _, r := range routines {
 		he.goRoutines[r] = NewRoutines()
 	}
-	// TODO: Create dockerClient
 	return he
 }
 
-// RecoverUnhealthy checks the list of running containers and tries
-// to automitigate if containers were not removed correctly.
-/*func (he *HealthEndpoint) RecoverUnhealthy() (err error) {
-
-}*/
-
 func (he *HealthEndpoint) SetHealth(status, msg string) (err error) {
-	v := []string{}
-	for _, e := range he.healthRing.Values() {
-		v = append(v, fmt.Sprintf("%s", e))
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	return he.setHealth(status, msg, time.Now())
+}
+
+func (he *HealthEndpoint) setHealth(status, msg string, t time.Time) (err error) {
+	if he.policy.Enabled() {
+		prev, _ := he.currentHealth()
+		err = he.checkTransition(prev, status, t)
+		if err != nil {
+			return
+		}
+		he.enqueueHealth(prev, status, msg, t)
+		return
 	}
+	// transitions are only evaluated over the most recent ringCapacity entries
+	entries := he.recent
+	v := []string{}
 	m := []string{}
-	for _, e := range he.healthMsgRing.Values() {
-		m = append(m, fmt.Sprintf("%s", e))
+	for _, e := range entries {
+		v = append(v, e.Status)
+		m = append(m, e.Message)
 	}
 
-	if status != Healthy && len(v) == 1 {
+	if status == Unhealthy && len(v) == 1 {
 		return fmt.Errorf("Status initialized with '%s'", status)
 	}
-	if status != Healthy && len(v) == ringCapacity {
+	if status == Unhealthy && len(v) == ringCapacity {
 		restIsUnhealthy := true
 		for _, i := range v[1:] {
 			if i != Unhealthy {
@@ -82,104 +129,427 @@ func (he *HealthEndpoint) SetHealth(status, msg string) (err error) {
 			return fmt.Errorf("Status becomes unhealthy for a ring-capacity (%d) duration: [%s]", ringCapacity, strings.Join(pair, ","))
 		}
 	}
-	he.healthRing.Enqueue(status)
-	he.healthMsgRing.Enqueue(msg)
+	he.enqueueHealth(v[len(v)-1], status, msg, t)
 	return
 }
 
-func (he *HealthEndpoint) AddRoutine(routineType string, rt Routine) (err error) {
-	_, ok := he.goRoutines[routineType]
-	if !ok {
-		return fmt.Errorf("Could not find routine type '%s'", routineType)
+// enqueueHealth records the evaluation; repeating the last status and message only updates
+// its count and time last seen instead of adding an entry to the history.
+func (he *HealthEndpoint) enqueueHealth(prev, status, msg string, t time.Time) {
+	e := NewHealthEntry(status, msg, t)
+	he.recent = append(he.recent, e)
+	if len(he.recent) > ringCapacity {
+		he.recent = he.recent[len(he.recent)-ringCapacity:]
 	}
-	he.goRoutines[routineType].Add(rt)
-	return
+	if last := he.lastHealthEntry(); last.Status == status && last.Message == msg {
+		last.Count++
+		last.LastSeen = t
+	} else {
+		he.healthRing.Enqueue(&e)
+	}
+	if prev != status {
+		he.changedAt = t
+		he.events.Publish("health", t, map[string]interface{}{
+			"status": status,
+			"previous": prev,
+			"message": msg,
+		})
+	}
+}
+
+// healthOrder ranks the statuses from best to worst.
+var healthOrder = map[string]int{
+	Healthy: 0,
+	Degraded: 1,
+	Starting: 2,
+	Unhealthy: 3,
 }
 
+// WorseHealth returns the worse of two statuses, unknown statuses rank as unhealthy.
+func WorseHealth(a, b string) string {
+	ra, ok := healthOrder[a]
+	if !ok {
+		ra = healthOrder[Unhealthy]
+	}
+	rb, ok := healthOrder[b]
+	if !ok {
+		rb = healthOrder[Unhealthy]
+	}
+	if rb > ra {
+		return b
+	}
+	return a
+}
 
+// AddRoutine starts the routine, see RoutineAction().
+func (he *HealthEndpoint) AddRoutine(routineType string, rt Routine) (err error) {
+	return he.RoutineAction(routineType, "start", rt)
+}
 
+// DelRoutine stops the routine, see RoutineAction().
 func (he *HealthEndpoint) DelRoutine(routineType string, rt Routine) (err error) {
-	_, ok := he.goRoutines[routineType]
+	return he.RoutineAction(routineType, "stop", rt)
+}
+
+// BeatRoutine refreshes the last beat of a known routine.
+func (he *HealthEndpoint) BeatRoutine(routineType string, rt Routine) (err error) {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	r, ok := he.goRoutines[routineType]
 	if !ok {
 		return fmt.Errorf("Could not find routine type '%s'", routineType)
 	}
-	he.goRoutines[routineType].Del(rt)
+	if err = r.Beat(rt); err != nil {
+		return fmt.Errorf("Could not beat routine '%s' of type '%s': %s", rt.GetID(), routineType, err)
+	}
 	return
 }
 
+// publishRoutine pushes a change of the routine set; 'del' events carry the final uptime of the routine.
+func (he *HealthEndpoint) publishRoutine(routineType, action string, rt Routine, t time.Time) {
+	data := map[string]interface{}{
+		"type": routineType,
+		"id": rt.GetID(),
+		"action": action,
+		"state": rt.state,
+		"count": he.goRoutines[routineType].Count(),
+	}
+	if action == "del" {
+		data["uptime"] = rt.uptime(t).String()
+	}
+	he.events.Publish("routine", t, data)
+}
+
 func (he *HealthEndpoint) CountRoutine(routine string) int {
-	r, ok := he.goRoutines[routine]
-	if !ok {
+	he.mu.RLock()
+	defer he.mu.RUnlock()
+	if _, ok := he.goRoutines[routine]; !ok {
 		return -1
 	}
-	return r.Count()
+	return len(he.activeRoutines(routine, time.Now()))
+}
+
+// SetRunningContainers stores the number of running containers reported by the engine. Once they
+// are unknown (-1), the containers are forgotten, so that no discrepancies are reported from stale data.
+func (he *HealthEndpoint) SetRunningContainers(cnt int) {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	he.cntCount = cnt
+	if cnt < 0 {
+		he.containers = nil
+		he.excluded = nil
+	}
+}
+
+// GetRunningContainers returns the last known number of running containers (-1 if unknown).
+func (he *HealthEndpoint) GetRunningContainers() int {
+	he.mu.RLock()
+	defer he.mu.RUnlock()
+	return he.cntCount
 }
 
 func (he *HealthEndpoint) GetJSON() map[string]interface{} {
+	he.mu.RLock()
+	defer he.mu.RUnlock()
 	return he.getJSON(time.Now())
 }
 
 func (he *HealthEndpoint) getJSON(t time.Time) map[string]interface{} {
 	routines :=  map[string]string{}
-	for n, r := range he.goRoutines {
-		routines[n] = r.String()
+	for n := range he.goRoutines {
+		routines[n] = strings.Join(he.activeRoutines(n, t), ",")
 	}
 	vitals :=  map[string]interface{}{}
 	for n, v := range he.vitals {
-		vitals[n] = v.getJSON(t)
+		vj := v.getJSON(t)
+		if l := he.vitalLevel(n, t); l != "" {
+			vj["stale"] = l
+		}
+		vitals[n] = vj
 	}
-	hStatus,hMsg := he.CurrentHealth()
+	hStatus,hMsg := he.currentHealth()
 	res := map[string]interface{}{
 		"status": hStatus,
 		"message": hMsg,
 		"routines": routines,
 		"vitals": vitals,
 	}
+	if he.lastReconcile != nil {
+		res["reconcile"] = he.lastReconcile.GetJSON()
+	}
+	if he.containers != nil {
+		res["discrepancies"] = he.getDiscrepanciesJSON(t)
+		res["pending"] = he.getPending(t)
+	}
+	if len(he.checkResults) > 0 {
+		res["checks"] = he.getChecksJSON()
+	}
+	if len(he.rejectedBeats) > 0 {
+		res["rejected_beats"] = he.getRejectedBeats()
+	}
+	if es, ok := he.engines[""]; ok {
+		res["docker"] = es.getJSON()
+	}
+	if len(he.namedEngines()) > 0 {
+		res["engines"] = he.getEnginesJSON(t)
+	}
+	if len(he.excluded) > 0 {
+		res["excluded"] = he.getExcluded()
+	}
+	if he.services != nil {
+		res["services"] = he.getServicesJSON()
+	}
+	if len(he.cntHealth) > 0 {
+		res["container_health"] = he.getContainerHealthJSON()
+	}
+	if len(he.invalidTransitions) > 0 {
+		res["invalid_transitions"] = he.getInvalidTransitions()
+	}
+	if stale := he.getStaleRoutines(t); len(stale) > 0 {
+		res["stale_routines"] = stale
+	}
 	return res
 }
 
 func (he *HealthEndpoint) CurrentHealth() (s, m string) {
-	sL := []string{}
-	for _, e := range he.healthRing.Values() {
-		sL = append(sL, fmt.Sprintf("%s", e))
-	}
-	mL := []string{}
-	for _, e := range he.healthMsgRing.Values() {
-		mL = append(mL, fmt.Sprintf("%s", e))
-	}
-	return sL[len(sL)-1], mL[len(mL)-1]
+	he.mu.RLock()
+	defer he.mu.RUnlock()
+	return he.currentHealth()
+}
+
+func (he *HealthEndpoint) currentHealth() (s, m string) {
+	entries := he.healthEntries()
+	e := entries[len(entries)-1]
+	return e.Status, e.Message
 }
 
 func (he *HealthEndpoint) GetTXT() string {
+	he.mu.RLock()
+	defer he.mu.RUnlock()
 	res := []string{}
-	hStatus,hMsg := he.CurrentHealth()
+	hStatus,hMsg := he.currentHealth()
 	res = append(res, fmt.Sprintf("health:%s | msg:%s", hStatus,hMsg))
+	if es := he.engines[""]; es.err != "" {
+		res = append(res, fmt.Sprintf("%s:%s | since:%s", dockerUnreachable, es.err, es.since.Format(time.RFC3339)))
+	}
 	keys := []string{}
 	for k, _ := range he.goRoutines {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
+	now := time.Now()
 	for _, n := range keys {
-		r := he.goRoutines[n]
-		res = append(res, fmt.Sprintf("%-15s: | %-2d | %s", n, r.Count(), r.String()))
+		active, stale := he.splitRoutines(n, now)
+		res = append(res, fmt.Sprintf("%-15s: | %-2d | %s", n, len(active), strings.Join(active, ",")))
+		if len(stale) > 0 {
+			res = append(res, fmt.Sprintf("%-15s: | %-2d | %s", "?"+n, len(stale), strings.Join(stale, ",")))
+		}
 	}
+	res = append(res, he.getEnginesTXT(now)...)
+	res = append(res, he.getDiscrepanciesTXT(now)...)
+	res = append(res, he.getServicesTXT()...)
 	return strings.Join(append(res, ""), "\n")
 }
 
+// SetUnhealthyCode sets the HTTP status code Handle() answers with while being unhealthy.
+func (he *HealthEndpoint) SetUnhealthyCode(code int) {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	he.unhealthyCode = code
+}
+
+// SetLiveTimeout sets how long after the last tick the endpoint is still considered alive.
+func (he *HealthEndpoint) SetLiveTimeout(d time.Duration) {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	he.liveTimeout = d
+}
+
+// SetDegradedCode sets the HTTP status code Handle() answers with while being degraded.
+func (he *HealthEndpoint) SetDegradedCode(code int) {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	he.degradedCode = code
+}
+
+// Tick marks the run loop as alive at time t.
+func (he *HealthEndpoint) Tick(t time.Time) {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	he.lastTick = t
+}
+
+// IsLive reports whether the run loop processed a tick within the live timeout.
+func (he *HealthEndpoint) IsLive() bool {
+	he.mu.RLock()
+	defer he.mu.RUnlock()
+	return he.isLive(time.Now())
+}
+
+func (he *HealthEndpoint) isLive(t time.Time) bool {
+	if he.lastTick.IsZero() {
+		return false
+	}
+	return t.Sub(he.lastTick) <= he.liveTimeout
+}
+
+// IsReady reports whether the current status is healthy or degraded.
+func (he *HealthEndpoint) IsReady() bool {
+	s, _ := he.CurrentHealth()
+	return isReady(s)
+}
+
+func isReady(status string) bool {
+	return status == Healthy || status == Degraded
+}
+
 func (he *HealthEndpoint) Handle(w http.ResponseWriter, req *http.Request) {
+	code := http.StatusOK
+	s, _ := he.CurrentHealth()
+	he.mu.RLock()
+	switch s {
+	case Unhealthy:
+		code = he.unhealthyCode
+	case Degraded:
+		code = he.degradedCode
+	}
+	he.mu.RUnlock()
 	if req.Header.Get("Accept") == "application/json" {
 		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
 		json.NewEncoder(w).Encode(he.GetJSON())
 	} else {
+		w.WriteHeader(code)
 		fmt.Fprint(w, he.GetTXT())
 	}
 }
 
+// HandleLive answers 200 as long as the run loop is processing ticks, 503 otherwise.
+func (he *HealthEndpoint) HandleLive(w http.ResponseWriter, req *http.Request) {
+	he.mu.RLock()
+	now := time.Now()
+	live := he.isLive(now)
+	res := map[string]interface{}{
+		"live": live,
+		"last_tick": he.lastTick.Format(time.RFC3339Nano),
+	}
+	he.mu.RUnlock()
+	code := http.StatusOK
+	if !live {
+		code = http.StatusServiceUnavailable
+	}
+	writeProbe(w, req, code, res)
+}
+
+// HandleReady answers 200 once the status is healthy (or degraded) and 503 while starting or unhealthy.
+func (he *HealthEndpoint) HandleReady(w http.ResponseWriter, req *http.Request) {
+	s, m := he.CurrentHealth()
+	code := http.StatusOK
+	if !isReady(s) {
+		code = http.StatusServiceUnavailable
+	}
+	res := map[string]interface{}{
+		"ready": isReady(s),
+		"status": s,
+		"message": m,
+	}
+	writeProbe(w, req, code, res)
+}
+
+// HandleRoutines serves /_health/routines[/{type}[/{id}]] as JSON, the id may contain the engine as '{engine}/{id}'.
+func (he *HealthEndpoint) HandleRoutines(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"error": "method not allowed"})
+		return
+	}
+	path := strings.Trim(strings.TrimPrefix(req.URL.Path, "/_health/routines"), "/")
+	parts := []string{}
+	if path != "" {
+		parts = strings.SplitN(path, "/", 2)
+	}
+	he.mu.RLock()
+	code, res := he.getRoutinesJSON(parts, time.Now())
+	he.mu.RUnlock()
+	writeJSON(w, code, res)
+}
+
+func (he *HealthEndpoint) getRoutinesJSON(parts []string, t time.Time) (int, interface{}) {
+	switch len(parts) {
+	case 0:
+		res := map[string]interface{}{}
+		for n, r := range he.goRoutines {
+			res[n] = r.getJSON(t, he.maxSilence[n])
+		}
+		return http.StatusOK, res
+	case 1, 2:
+		r, ok := he.goRoutines[parts[0]]
+		if !ok {
+			return http.StatusNotFound, map[string]interface{}{"error": fmt.Sprintf("Could not find routine type '%s'", parts[0])}
+		}
+		if len(parts) == 1 {
+			return http.StatusOK, r.getJSON(t, he.maxSilence[parts[0]])
+		}
+		rt, ok := r.GetRoutine(parts[1])
+		if !ok {
+			rt, ok = he.getStopped(parts[0], parts[1])
+		}
+		if !ok {
+			return http.StatusNotFound, map[string]interface{}{"error": fmt.Sprintf("Could not find routine '%s' of type '%s'", parts[1], parts[0])}
+		}
+		rj := rt.getJSON(t)
+		rj["stale"] = rt.state != RoutineStopped && rt.IsStale(t, he.maxSilence[parts[0]])
+		return http.StatusOK, rj
+	}
+	return http.StatusNotFound, map[string]interface{}{"error": "not found"}
+}
+
+func writeJSON(w http.ResponseWriter, code int, res interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(res)
+}
+
+func writeProbe(w http.ResponseWriter, req *http.Request, code int, res map[string]interface{}) {
+	if req.Header.Get("Accept") == "application/json" {
+		writeJSON(w, code, res)
+		return
+	}
+	w.WriteHeader(code)
+	fmt.Fprintln(w, http.StatusText(code))
+}
+
 /// Vitals
 func (he *HealthEndpoint) UpsertVitals(name, state string , t time.Time) {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	prev := ""
 	if v, ok := he.vitals[name]; !ok {
 		 he.vitals[name] = newVitals(t, state)
 	} else {
+		prev = v.LastState
 		v.UpdateLast(t, state)
 	}
+	if prev != state {
+		he.events.Publish("vitals", t, map[string]interface{}{
+			"name": name,
+			"status": state,
+			"previous": prev,
+		})
+	}
+}
+
+// GetVital returns a copy of the vital with the given name.
+func (he *HealthEndpoint) GetVital(name string) (v Vitals, ok bool) {
+	he.mu.RLock()
+	defer he.mu.RUnlock()
+	vp, ok := he.vitals[name]
+	if ok {
+		v = *vp
+	}
+	return
+}
+
+// Events returns the broker publishing changes of the endpoint.
+func (he *HealthEndpoint) Events() *EventBroker {
+	return he.events
 }
@@ -5,6 +5,8 @@ import (
 	"github.com/stretchr/testify/assert"
 	"strings"
 	"time"
+	"net/http"
+	"net/http/httptest"
 )
 
 var (
@@ -111,3 +113,91 @@ func TestHealthEndpoint_SetHealth(t *testing.T) {
 	err = he.SetHealth("unhealthy", "some error")
 	assert.NoErrorf(t, err, "Ring has not reached fill capacity, move on")
 }
+
+func TestHealthEndpoint_HandleReady(t *testing.T) {
+	he := NewHealthEndpoint([]string{})
+	rec := httptest.NewRecorder()
+	he.HandleReady(rec, httptest.NewRequest("GET", "/_health/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code, "Still starting")
+	he.SetHealth(Healthy, "I am fine")
+	rec = httptest.NewRecorder()
+	he.HandleReady(rec, httptest.NewRequest("GET", "/_health/ready", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	he.SetHealth(Unhealthy, "some error")
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/_health/ready", nil)
+	req.Header.Set("Accept", "application/json")
+	he.HandleReady(rec, req)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "{\"message\":\"some error\",\"ready\":false,\"status\":\"unhealthy\"}\n", rec.Body.String())
+}
+
+func TestHealthEndpoint_HandleLive(t *testing.T) {
+	he := NewHealthEndpoint([]string{})
+	rec := httptest.NewRecorder()
+	he.HandleLive(rec, httptest.NewRequest("GET", "/_health/live", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code, "No tick processed yet")
+	he.Tick(time.Now())
+	rec = httptest.NewRecorder()
+	he.HandleLive(rec, httptest.NewRequest("GET", "/_health/live", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	he.Tick(time.Now().Add(-2*defaultLiveTimeout))
+	assert.False(t, he.IsLive(), "Ticks stopped")
+}
+
+func TestHealthEndpoint_HandleUnhealthyCode(t *testing.T) {
+	he := NewHealthEndpoint([]string{})
+	he.SetHealth(Healthy, "I am fine")
+	he.SetHealth(Unhealthy, "some error")
+	rec := httptest.NewRecorder()
+	he.Handle(rec, httptest.NewRequest("GET", "/_health", nil))
+	assert.Equal(t, http.StatusOK, rec.Code, "Defaults to 200")
+	he.SetUnhealthyCode(http.StatusServiceUnavailable)
+	rec = httptest.NewRecorder()
+	he.Handle(rec, httptest.NewRequest("GET", "/_health", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestHealthEndpoint_HandleRoutines(t *testing.T) {
+	he := NewHealthEndpoint([]string{"test"})
+	he.AddRoutine("test", rt1)
+	code, res := he.getRoutinesJSON([]string{}, ts)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, res.(map[string]interface{})["test"], 1)
+	code, res = he.getRoutinesJSON([]string{"test", "id1"}, ts.Add(time.Minute))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "1m0s", res.(map[string]interface{})["age"])
+	code, _ = he.getRoutinesJSON([]string{"test", "id2"}, ts)
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = he.getRoutinesJSON([]string{"nil"}, ts)
+	assert.Equal(t, http.StatusNotFound, code)
+	rec := httptest.NewRecorder()
+	he.HandleRoutines(rec, httptest.NewRequest("GET", "/_health/routines/test", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "\"id\":\"id1\"")
+	rec = httptest.NewRecorder()
+	he.HandleRoutines(rec, httptest.NewRequest("POST", "/_health/routines", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestWorseHealth(t *testing.T) {
+	assert.Equal(t, Degraded, WorseHealth(Healthy, Degraded))
+	assert.Equal(t, Unhealthy, WorseHealth(Unhealthy, Degraded))
+	assert.Equal(t, Starting, WorseHealth(Starting, Degraded))
+	assert.Equal(t, "unknown", WorseHealth(Healthy, "unknown"))
+}
+
+func TestHealthEndpoint_Degraded(t *testing.T) {
+	he := NewHealthEndpoint([]string{})
+	err := he.SetHealth(Degraded, "soft problem")
+	assert.NoError(t, err, "Degraded is accepted right away")
+	assert.True(t, he.IsReady())
+	assert.Equal(t, "health:degraded | msg:soft problem\n", he.GetTXT())
+	he.SetDegradedCode(http.StatusTooManyRequests)
+	rec := httptest.NewRecorder()
+	he.Handle(rec, httptest.NewRequest("GET", "/_health", nil))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	rec = httptest.NewRecorder()
+	he.HandleReady(rec, httptest.NewRequest("GET", "/_health/ready", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
@@ -0,0 +1,283 @@
+package qcache_health
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/tlsconfig"
+)
+
+const (
+	// engineTag is the message tag naming the engine a HealthBeat or ContainerEvent originates from.
+	engineTag = "engine"
+	engineSep = "/"
+)
+
+// dockerEngine is a docker engine watched by the plugin. The unnamed engine is configured by
+// 'docker-host', named engines by 'engines.<name>.docker-host'.
+type dockerEngine struct {
+	name 		string
+	cli 		*client.Client
+	conn 		dockerConn
+	inventory 	containerInventory
+}
+
+// cfgKey returns the configuration key of the engine, e.g. 'engines.<name>.docker-host'.
+func (e *dockerEngine) cfgKey(key string) string {
+	if e.name == "" {
+		return key
+	}
+	return fmt.Sprintf("engines.%s.%s", e.name, key)
+}
+
+// engineID namespaces a container or routine ID with the engine name, unless the engine is unnamed.
+func engineID(engine, id string) string {
+	if engine == "" {
+		return id
+	}
+	return engine + engineSep + id
+}
+
+// splitEngineID splits a namespaced ID into the engine name and the ID.
+func splitEngineID(id string) (engine, rest string) {
+	if i := strings.LastIndex(id, engineSep); i >= 0 {
+		return id[:i], id[i+1:]
+	}
+	return "", id
+}
+
+// parseEngines reads the comma separated names of 'engines', falling back to the unnamed engine.
+func (p *Plugin) parseEngines() (res []*dockerEngine, err error) {
+	names := p.CfgStringOr("engines", "")
+	if names == "" {
+		return []*dockerEngine{{}}, nil
+	}
+	seen := map[string]bool{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || strings.Contains(name, engineSep) || seen[name] {
+			return nil, fmt.Errorf("Could not use engine name '%s' of 'engines': empty, duplicate or containing '%s'", name, engineSep)
+		}
+		seen[name] = true
+		e := &dockerEngine{name: name}
+		if p.CfgStringOr(e.cfgKey("docker-host"), "") == "" {
+			return nil, fmt.Errorf("Could not find '%s' for engine '%s'", e.cfgKey("docker-host"), name)
+		}
+		res = append(res, e)
+	}
+	return
+}
+
+// newDockerClient creates the client of the engine, using TLS if 'docker-tls-ca' or 'docker-tls-cert' is set.
+func (p *Plugin) newDockerClient(e *dockerEngine) (*client.Client, error) {
+	dockerHost := p.CfgStringOr(e.cfgKey("docker-host"), "unix:///var/run/docker.sock")
+	var hc *http.Client
+	ca := p.CfgStringOr(e.cfgKey("docker-tls-ca"), "")
+	cert := p.CfgStringOr(e.cfgKey("docker-tls-cert"), "")
+	if ca != "" || cert != "" {
+		tlsc, err := tlsconfig.Client(tlsconfig.Options{
+			CAFile: ca,
+			CertFile: cert,
+			KeyFile: p.CfgStringOr(e.cfgKey("docker-tls-key"), ""),
+			InsecureSkipVerify: !p.CfgBoolOr(e.cfgKey("docker-tls-verify"), true),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Could not load TLS config of '%s': %v", dockerHost, err)
+		}
+		hc = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsc}, CheckRedirect: client.CheckRedirect}
+	}
+	cli, err := client.NewClient(dockerHost, p.CfgStringOr(e.cfgKey("docker-api-version"), ""), hc, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Could not connect docker/docker/client to '%s': %v", dockerHost, err)
+	}
+	return cli, nil
+}
+
+// getEngine returns the engine by name.
+func (p *Plugin) getEngine(name string) *dockerEngine {
+	for _, e := range p.engines {
+		if e.name == name {
+			return e
+		}
+	}
+	return nil
+}
+
+// engineFor returns the engine a message originates from: the only engine, or the one named by the
+// 'engine' tag if several are watched. It returns nil for untagged messages of several engines.
+func (p *Plugin) engineFor(tags map[string]string) *dockerEngine {
+	if len(p.engines) == 1 {
+		return p.engines[0]
+	}
+	return p.getEngine(tags[engineTag])
+}
+
+// routineActor namespaces the actor of a HealthBeat with its engine, so that it matches the
+// containers of that engine.
+func routineActor(e *dockerEngine, actor string) string {
+	if strings.HasPrefix(actor, e.name+engineSep) {
+		return actor
+	}
+	return engineID(e.name, actor)
+}
+
+// reachableEngines counts the engines, which answered the last call.
+func (p *Plugin) reachableEngines() (cnt int) {
+	for _, e := range p.engines {
+		if e.conn.reachable {
+			cnt++
+		}
+	}
+	return
+}
+
+// engineStatus is the connection state of an engine as reported by the HealthEndpoint.
+type engineStatus struct {
+	err 		string
+	since 		time.Time
+	apiVersion 	string
+}
+
+func (es engineStatus) getJSON() map[string]interface{} {
+	res := map[string]interface{}{
+		"reachable": es.err == "",
+		"api_version": es.apiVersion,
+	}
+	if es.err != "" {
+		res["error"] = es.err
+		res["since"] = es.since.Format(time.RFC3339Nano)
+	}
+	return res
+}
+
+// SetEngineUnreachable records the error of the failed call to the engine, keeping the time since it fails.
+func (he *HealthEndpoint) SetEngineUnreachable(engine, msg string, t time.Time) {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	es := he.engines[engine]
+	if es.err == "" {
+		es.since = t
+	}
+	es.err = msg
+	he.engines[engine] = es
+}
+
+// SetEngineReachable clears the error of the engine and stores the negotiated API version.
+func (he *HealthEndpoint) SetEngineReachable(engine, apiVersion string) {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	he.engines[engine] = engineStatus{apiVersion: apiVersion}
+}
+
+// GetUnreachableEngines returns the names of the named engines failing to answer.
+func (he *HealthEndpoint) GetUnreachableEngines() []string {
+	he.mu.RLock()
+	defer he.mu.RUnlock()
+	return he.getUnreachableEngines()
+}
+
+func (he *HealthEndpoint) getUnreachableEngines() []string {
+	res := []string{}
+	for n, es := range he.engines {
+		if n != "" && es.err != "" {
+			res = append(res, n)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (he *HealthEndpoint) namedEngines() []string {
+	res := []string{}
+	for n := range he.engines {
+		if n != "" {
+			res = append(res, n)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// getEngineHealth computes the status of a named engine: unhealthy if it is unreachable or one of
+// its containers lacks a routine, along with its running containers and those lacking a routine.
+func (he *HealthEndpoint) getEngineHealth(engine string, discrepancies map[string]Discrepancy) (status string, running int, missing []string) {
+	prefix := engine + engineSep
+	for id := range he.containers {
+		if strings.HasPrefix(id, prefix) {
+			running++
+		}
+	}
+	missing = []string{}
+	groups := []string{}
+	for g := range discrepancies {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+	for _, g := range groups {
+		for _, ref := range discrepancies[g].Missing {
+			if strings.HasPrefix(ref, prefix) || strings.Contains(ref, "("+prefix) {
+				missing = append(missing, fmt.Sprintf("%s:%s", g, ref))
+			}
+		}
+	}
+	status = Healthy
+	if he.engines[engine].err != "" || len(missing) > 0 {
+		status = Unhealthy
+	}
+	return
+}
+
+func (he *HealthEndpoint) getEnginesJSON(t time.Time) map[string]interface{} {
+	discrepancies := he.getDiscrepancies(t)
+	res := map[string]interface{}{}
+	for _, n := range he.namedEngines() {
+		ej := he.engines[n].getJSON()
+		status, running, missing := he.getEngineHealth(n, discrepancies)
+		ej["status"] = status
+		ej["running_containers"] = running
+		ej["missing"] = missing
+		res[n] = ej
+	}
+	return res
+}
+
+func (he *HealthEndpoint) getEnginesTXT(t time.Time) []string {
+	discrepancies := he.getDiscrepancies(t)
+	res := []string{}
+	for _, n := range he.namedEngines() {
+		status, running, missing := he.getEngineHealth(n, discrepancies)
+		line := fmt.Sprintf("engine:%s | health:%s | containers:%d", n, status, running)
+		if es := he.engines[n]; es.err != "" {
+			line = fmt.Sprintf("%s | %s:%s | since:%s", line, dockerUnreachable, es.err, es.since.Format(time.RFC3339))
+		}
+		if len(missing) > 0 {
+			line = fmt.Sprintf("%s | missing:%s", line, strings.Join(missing, ","))
+		}
+		res = append(res, line)
+	}
+	return res
+}
+
+// NewDockerChecker reports unhealthy as long as an engine is unreachable. With several engines,
+// the containers of the others are still checked.
+func NewDockerChecker() Checker {
+	return NewCheckerFunc("docker", func(he *HealthEndpoint) CheckResult {
+		he.mu.RLock()
+		unnamed := he.engines[""]
+		unreachable := he.getUnreachableEngines()
+		he.mu.RUnlock()
+		res := CheckResult{Status: Healthy, Details: map[string]interface{}{"unreachable": unreachable}}
+		switch {
+		case unnamed.err != "":
+			res.Status = Unhealthy
+			res.Message = fmt.Sprintf("%s: %s", dockerUnreachable, unnamed.err)
+		case len(unreachable) > 0:
+			res.Status = Unhealthy
+			res.Message = fmt.Sprintf("%s:[%s]", dockerUnreachable, strings.Join(unreachable, ","))
+		}
+		return res
+	})
+}
@@ -0,0 +1,179 @@
+package qcache_health
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/docker/docker/api/types"
+	"github.com/qframe/types/health"
+	"github.com/qframe/types/messages"
+	"github.com/qframe/types/qchannel"
+	"github.com/zpatrick/go-config"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func engineHost(srv *httptest.Server) string {
+	return strings.Replace(strings.Replace(srv.URL, "https://", "tcp://", 1), "http://", "tcp://", 1)
+}
+
+func engineBeat(engine, typ, actor string) qtypes_health.HealthBeat {
+	b := qtypes_messages.NewBase("test")
+	b.Tags = map[string]string{engineTag: engine}
+	return qtypes_health.NewHealthBeat(b, "routine."+typ, actor, "start")
+}
+
+func TestPlugin_parseEngines(t *testing.T) {
+	p := newCfgPlugin(t, map[string]string{"log.level": "error"})
+	assert.Len(t, p.engines, 1)
+	assert.Equal(t, "docker-host", p.engines[0].cfgKey("docker-host"))
+	assert.Contains(t, p.HealthEndpoint.Checkers(), "docker")
+	p = newCfgPlugin(t, map[string]string{
+		"log.level": "error",
+		"cache.test.engines": "a, b",
+		"cache.test.engines.a.docker-host": "tcp://10.0.0.1:2376",
+		"cache.test.engines.b.docker-host": "tcp://10.0.0.2:2376",
+	})
+	assert.Equal(t, "a", p.engines[0].name)
+	assert.Equal(t, "engines.b.docker-host", p.engines[1].cfgKey("docker-host"))
+	for _, cfgMap := range []map[string]string{
+		{"cache.test.engines": "a"},
+		{"cache.test.engines": "a,a", "cache.test.engines.a.docker-host": "tcp://10.0.0.1:2376"},
+		{"cache.test.engines": "a/b", "cache.test.engines.a/b.docker-host": "tcp://10.0.0.1:2376"},
+	} {
+		cfgMap["log.level"] = "error"
+		_, err := New(qtypes_qchannel.NewQChan(), config.NewConfig([]config.Provider{config.NewStatic(cfgMap)}), "test")
+		assert.Error(t, err, "%v", cfgMap)
+	}
+}
+
+func TestEngineID(t *testing.T) {
+	assert.Equal(t, "aaaaaaaaaaaa", engineID("", "aaaaaaaaaaaa"))
+	assert.Equal(t, "a/aaaaaaaaaaaa", shortID(engineID("a", "aaaaaaaaaaaa0000")))
+	e, id := splitEngineID("a/aaaaaaaaaaaa")
+	assert.Equal(t, "a", e)
+	assert.Equal(t, "aaaaaaaaaaaa", id)
+}
+
+func TestPlugin_engines(t *testing.T) {
+	srvA := fakeDocker(t, []types.Container{{ID: "aaaaaaaaaaaa0000", Names: []string{"/web"}}})
+	defer srvA.Close()
+	srvB := fakeDocker(t, []types.Container{{ID: "bbbbbbbbbbbb0000", Names: []string{"/db"}}})
+	defer srvB.Close()
+	p := newCfgPlugin(t, map[string]string{
+		"log.level": "error",
+		"cache.test.ignore-logs": "true",
+		"cache.test.engines": "a,b",
+		"cache.test.engines.a.docker-host": engineHost(srvA),
+		"cache.test.engines.b.docker-host": engineHost(srvB),
+	})
+	p.SetHealth(Healthy, "Start")
+	assert.True(t, p.ensureEngines(time.Now()))
+	p.handleHB(engineBeat("a", "stats", "aaaaaaaaaaaa"))
+	p.handleHB(engineBeat("b", "stats", "bbbbbbbbbbbb"))
+	assert.Equal(t, []string{"a/aaaaaaaaaaaa", "b/bbbbbbbbbbbb"}, p.HealthEndpoint.goRoutines["stats"].Get())
+	p.handleHB(engineBeat("", "stats", "cccccccccccc"))
+	p.handleHB(engineBeat("c", "stats", "cccccccccccc"))
+	assert.Equal(t, 2, p.HealthEndpoint.CountRoutine("stats"), "Beats of unknown engines are rejected")
+	assert.Equal(t, 2, p.HealthEndpoint.GetRejectedBeats()["routine.stats"])
+	cntCount := p.getRunningCntCount()
+	assert.Equal(t, 2, cntCount)
+	p.checkHealth(cntCount)
+	s, _ := p.HealthEndpoint.CurrentHealth()
+	assert.Equal(t, Healthy, s)
+	ej := p.HealthEndpoint.GetJSON()["engines"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{
+		"reachable": true, "api_version": "1.29", "status": Healthy, "running_containers": 1, "missing": []string{},
+	}, ej["a"])
+	// Engine b fails while the containers of engine a are still checked
+	srvB.Close()
+	p.HealthEndpoint.DelRoutine("stats", NewRoutine("a/aaaaaaaaaaaa", "stop", time.Now()))
+	cntCount = p.getRunningCntCount()
+	assert.Equal(t, 1, cntCount)
+	p.checkHealth(cntCount)
+	s, m := p.HealthEndpoint.CurrentHealth()
+	assert.Equal(t, Unhealthy, s)
+	assert.Contains(t, m, "docker-unreachable:[b]")
+	assert.Equal(t, []string{"b"}, p.HealthEndpoint.GetUnreachableEngines())
+	assert.Equal(t, Unhealthy, p.HealthEndpoint.GetJSON()["checks"].(map[string]interface{})["docker"].(map[string]interface{})["status"])
+	ej = p.HealthEndpoint.GetJSON()["engines"].(map[string]interface{})
+	assert.Equal(t, []string{"stats:web(a/aaaaaaaaaaaa)"}, ej["a"].(map[string]interface{})["missing"])
+	assert.Equal(t, false, ej["b"].(map[string]interface{})["reachable"])
+	assert.Equal(t, Unhealthy, ej["b"].(map[string]interface{})["status"])
+	txt := p.HealthEndpoint.GetTXT()
+	assert.Contains(t, txt, "\nengine:a | health:unhealthy | containers:1 | missing:stats:web(a/aaaaaaaaaaaa)\n")
+	assert.Contains(t, txt, "\nengine:b | health:unhealthy | containers:0 | docker-unreachable:Info(): ")
+	assert.Contains(t, p.HealthEndpoint.GetMetrics(), "qframe_health_docker_reachable{engine=\"b\"} 0\n")
+	assert.False(t, p.HealthEndpoint.IsDockerReachable())
+	// Only once no engine is left, the running containers are unknown
+	srvA.Close()
+	cntCount = p.getRunningCntCount()
+	assert.Equal(t, -1, cntCount)
+	p.checkHealth(cntCount)
+	_, m = p.HealthEndpoint.CurrentHealth()
+	assert.Equal(t, "docker-unreachable:[a,b]", m)
+}
+
+func TestPlugin_engineEvents(t *testing.T) {
+	srvA := fakeDocker(t, []types.Container{})
+	defer srvA.Close()
+	srvB := fakeDocker(t, []types.Container{})
+	defer srvB.Close()
+	p := newCfgPlugin(t, map[string]string{
+		"log.level": "error",
+		"cache.test.engines": "a,b",
+		"cache.test.engines.a.docker-host": engineHost(srvA),
+		"cache.test.engines.b.docker-host": engineHost(srvB),
+	})
+	assert.True(t, p.ensureEngines(time.Now()))
+	assert.Equal(t, 0, p.getRunningCntCount())
+	ce := containerEvent("start", "bbbbbbbbbbbb0000", "db", nil)
+	ce.Tags = map[string]string{engineTag: "b"}
+	p.handleContainerEvent(ce)
+	untagged := containerEvent("start", "cccccccccccc0000", "cache", nil)
+	untagged.Tags = map[string]string{}
+	p.handleContainerEvent(untagged)
+	assert.Len(t, p.engines[0].inventory.cnts, 0)
+	assert.Len(t, p.engines[1].inventory.cnts, 1)
+	assert.False(t, p.engines[0].inventory.eventDriven, "Untagged events do not replace the resync")
+	assert.Equal(t, 1, p.getRunningCntCount())
+	assert.Equal(t, map[string]string{"b/bbbbbbbbbbbb": "db"}, p.HealthEndpoint.containers)
+}
+
+func TestPlugin_engineTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(fakeDocker(t, []types.Container{}).Config.Handler)
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "cache-health")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600)
+	p := newCfgPlugin(t, map[string]string{
+		"log.level": "error",
+		"cache.test.engines": "remote",
+		"cache.test.engines.remote.docker-host": engineHost(srv),
+		"cache.test.engines.remote.docker-tls-ca": caFile,
+	})
+	assert.NoError(t, p.connectingDocker(p.engines[0]))
+	assert.Equal(t, "1.29", p.engines[0].cli.ClientVersion())
+	p = newCfgPlugin(t, map[string]string{
+		"log.level": "error",
+		"cache.test.engines": "remote",
+		"cache.test.engines.remote.docker-host": engineHost(srv),
+		"cache.test.engines.remote.docker-tls-ca": filepath.Join(dir, "missing.pem"),
+	})
+	assert.Error(t, p.connectingDocker(p.engines[0]))
+}
+
+func TestHealthEndpoint_getRoutinesJSONEngine(t *testing.T) {
+	he := NewHealthEndpoint([]string{"log"})
+	he.AddRoutine("log", NewRoutine("a/aaaaaaaaaaaa", "start", time.Now()))
+	rec := httptest.NewRecorder()
+	he.HandleRoutines(rec, httptest.NewRequest("GET", "/_health/routines/log/a/aaaaaaaaaaaa", nil))
+	assert.Equal(t, 200, rec.Code)
+	assert.Contains(t, rec.Body.String(), "a/aaaaaaaaaaaa")
+}
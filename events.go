@@ -0,0 +1,206 @@
+package qcache_health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	eventBacklog = 256
+	eventSubBuffer = 64
+	eventKeepAlive = 15 * time.Second
+)
+
+// Event describes a change within the HealthEndpoint, pushed to /_health/events subscribers.
+// Its SSE id '<epoch>-<id>' stays unique across restarts, as the epoch is fixed per EventBroker.
+type Event struct {
+	Epoch 	string
+	ID 		uint64
+	Type 	string
+	Time 	time.Time
+	Data 	map[string]interface{}
+}
+
+// EventBroker fans out events to subscribers and keeps a backlog to resume from.
+type EventBroker struct {
+	mu 		sync.Mutex
+	epoch 	string
+	lastID 	uint64
+	backlog []Event
+	subs 	map[chan Event]struct{}
+}
+
+func NewEventBroker() *EventBroker {
+	return &EventBroker{
+		epoch: strconv.FormatInt(time.Now().UnixNano(), 36),
+		backlog: []Event{},
+		subs: map[chan Event]struct{}{},
+	}
+}
+
+// Publish assigns the next ID to the event and sends it to all subscribers.
+// Subscribers that do not keep up are dropped and have to resume via Last-Event-ID.
+func (eb *EventBroker) Publish(typ string, t time.Time, data map[string]interface{}) Event {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	eb.lastID++
+	e := Event{Epoch: eb.epoch, ID: eb.lastID, Type: typ, Time: t, Data: data}
+	eb.backlog = append(eb.backlog, e)
+	if len(eb.backlog) > eventBacklog {
+		eb.backlog = eb.backlog[len(eb.backlog)-eventBacklog:]
+	}
+	for c := range eb.subs {
+		select {
+		case c <- e:
+		default:
+			delete(eb.subs, c)
+			close(c)
+		}
+	}
+	return e
+}
+
+// Subscribe returns the backlog newer than lastID and a channel receiving all further events.
+// An empty epoch subscribes without resuming. If lastID stems from another epoch or is older than
+// the backlog, the whole backlog is returned along with the reason to reset the subscriber's state.
+func (eb *EventBroker) Subscribe(epoch string, lastID uint64) (missed []Event, reset string, c chan Event) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	switch {
+	case epoch == "":
+	case epoch != eb.epoch:
+		reset, lastID = "restarted", 0
+	case len(eb.backlog) > 0 && lastID+1 < eb.backlog[0].ID:
+		reset, lastID = "gap", 0
+	}
+	missed = []Event{}
+	for _, e := range eb.backlog {
+		if e.ID > lastID {
+			missed = append(missed, e)
+		}
+	}
+	c = make(chan Event, eventSubBuffer)
+	eb.subs[c] = struct{}{}
+	return
+}
+
+// Unsubscribe removes the channel, unless it was already dropped by Publish.
+func (eb *EventBroker) Unsubscribe(c chan Event) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	if _, ok := eb.subs[c]; ok {
+		delete(eb.subs, c)
+		close(c)
+	}
+}
+
+// CloseSubscribers closes all subscriptions, which ends the open event streams, e.g. on shutdown.
+func (eb *EventBroker) CloseSubscribers() {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	for c := range eb.subs {
+		delete(eb.subs, c)
+		close(c)
+	}
+}
+
+// GetEventID returns the SSE id of the event.
+func (e Event) GetEventID() string {
+	return fmt.Sprintf("%s-%d", e.Epoch, e.ID)
+}
+
+// parseEventID splits a Last-Event-ID into the epoch and the ID of the event.
+func parseEventID(lid string) (epoch string, id uint64, err error) {
+	i := strings.LastIndex(lid, "-")
+	if i <= 0 {
+		return "", 0, fmt.Errorf("Could not parse Last-Event-ID '%s'", lid)
+	}
+	id, err = strconv.ParseUint(lid[i+1:], 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("Could not parse Last-Event-ID '%s'", lid)
+	}
+	return lid[:i], id, nil
+}
+
+// writeReset tells the subscriber that events were missed and its state has to be fetched anew.
+func writeReset(w http.ResponseWriter, reason string, t time.Time) error {
+	b, err := json.Marshal(map[string]interface{}{"reason": reason, "time": t.Format(time.RFC3339Nano)})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: reset\ndata: %s\n\n", b)
+	return err
+}
+
+func (e Event) write(w http.ResponseWriter) error {
+	data := map[string]interface{}{
+		"time": e.Time.Format(time.RFC3339Nano),
+	}
+	for k, v := range e.Data {
+		data[k] = v
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.GetEventID(), e.Type, b)
+	return err
+}
+
+// HandleEvents streams events as Server-Sent Events, resuming after the Last-Event-ID header.
+// A 'reset' event precedes the replayed backlog if events were missed in between.
+func (he *HealthEndpoint) HandleEvents(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	epoch, lastID := "", uint64(0)
+	if lid := req.Header.Get("Last-Event-ID"); lid != "" {
+		var err error
+		epoch, lastID, err = parseEventID(lid)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	missed, reset, c := he.events.Subscribe(epoch, lastID)
+	defer he.events.Unsubscribe(c)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	if reset != "" && writeReset(w, reset, time.Now()) != nil {
+		return
+	}
+	for _, e := range missed {
+		if e.write(w) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case e, ok := <-c:
+			if !ok {
+				return
+			}
+			if e.write(w) != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
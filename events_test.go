@@ -0,0 +1,138 @@
+package qcache_health
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"time"
+	"net/http"
+	"net/http/httptest"
+	"context"
+	"strings"
+)
+
+func TestEventBroker(t *testing.T) {
+	eb := NewEventBroker()
+	eb.Publish("health", ts, map[string]interface{}{"status": Healthy})
+	missed, reset, c := eb.Subscribe("", 0)
+	assert.Len(t, missed, 1)
+	assert.Equal(t, "", reset)
+	e := eb.Publish("health", ts, map[string]interface{}{"status": Unhealthy})
+	assert.Equal(t, uint64(2), e.ID)
+	got := <-c
+	assert.Equal(t, e, got)
+	eb.Unsubscribe(c)
+	missed, reset, c = eb.Subscribe(eb.epoch, 1)
+	assert.Len(t, missed, 1)
+	assert.Equal(t, "", reset)
+	assert.Equal(t, uint64(2), missed[0].ID)
+	eb.Unsubscribe(c)
+	missed, reset, c = eb.Subscribe("other", 5)
+	assert.Len(t, missed, 2, "IDs of another epoch replay the whole backlog")
+	assert.Equal(t, "restarted", reset)
+	eb.Unsubscribe(c)
+}
+
+func TestEventBroker_Gap(t *testing.T) {
+	eb := NewEventBroker()
+	for i := 0; i < eventBacklog+2; i++ {
+		eb.Publish("vitals", ts, nil)
+	}
+	missed, reset, c := eb.Subscribe(eb.epoch, 1)
+	assert.Len(t, missed, eventBacklog)
+	assert.Equal(t, "gap", reset)
+	eb.Unsubscribe(c)
+	missed, reset, c = eb.Subscribe(eb.epoch, 2)
+	assert.Len(t, missed, eventBacklog)
+	assert.Equal(t, "", reset, "The backlog starts right after event 2")
+	eb.Unsubscribe(c)
+}
+
+func TestParseEventID(t *testing.T) {
+	epoch, id, err := parseEventID("k1x2-42")
+	assert.NoError(t, err)
+	assert.Equal(t, "k1x2", epoch)
+	assert.Equal(t, uint64(42), id)
+	for _, lid := range []string{"42", "-42", "k1x2-", "k1x2-a"} {
+		_, _, err = parseEventID(lid)
+		assert.Error(t, err, lid)
+	}
+}
+
+func TestEventBroker_SlowSubscriber(t *testing.T) {
+	eb := NewEventBroker()
+	_, _, c := eb.Subscribe("", 0)
+	for i := 0; i <= eventSubBuffer; i++ {
+		eb.Publish("vitals", ts, nil)
+	}
+	cnt := 0
+	for range c {
+		cnt++
+	}
+	assert.Equal(t, eventSubBuffer, cnt, "Channel is closed once the subscriber falls behind")
+	eb.Unsubscribe(c)
+}
+
+func TestHealthEndpoint_Events(t *testing.T) {
+	he := NewHealthEndpoint([]string{"test"})
+	missed, _, c := he.Events().Subscribe("", 0)
+	defer he.Events().Unsubscribe(c)
+	assert.Len(t, missed, 0)
+	he.AddRoutine("test", rt1)
+	he.AddRoutine("test", rt1)
+	e := <-c
+	assert.Equal(t, "routine", e.Type)
+	assert.Equal(t, "add", e.Data["action"])
+	assert.Equal(t, 1, e.Data["count"])
+	he.DelRoutine("test", rt2)
+	he.DelRoutine("test", rt1)
+	e = <-c
+	assert.Equal(t, "del", e.Data["action"])
+	he.SetHealth(Healthy, "I am fine")
+	he.SetHealth(Healthy, "I am fine")
+	e = <-c
+	assert.Equal(t, "health", e.Type)
+	assert.Equal(t, Starting, e.Data["previous"])
+	he.UpsertVitals("v1", "init", ts)
+	he.UpsertVitals("v1", "init", ts)
+	e = <-c
+	assert.Equal(t, "vitals", e.Type)
+	assert.Equal(t, uint64(4), e.ID)
+	select {
+	case e = <-c:
+		t.Errorf("Unexpected event %v", e)
+	default:
+	}
+}
+
+func TestHealthEndpoint_HandleEvents(t *testing.T) {
+	he := NewHealthEndpoint([]string{"test"})
+	he.AddRoutine("test", rt1)
+	he.AddRoutine("test", rt2)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest("GET", "/_health/events", nil).WithContext(ctx)
+	epoch := he.Events().epoch
+	req.Header.Set("Last-Event-ID", epoch+"-1")
+	rec := httptest.NewRecorder()
+	he.HandleEvents(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"))
+	body := rec.Body.String()
+	assert.False(t, strings.Contains(body, "id: "+epoch+"-1\n"), "Event 1 was already seen")
+	assert.True(t, strings.HasPrefix(body, "id: "+epoch+"-2\nevent: routine\ndata: {"), body)
+	// An ID of a previous process resets the subscriber
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req = httptest.NewRequest("GET", "/_health/events", nil).WithContext(ctx)
+	req.Header.Set("Last-Event-ID", "old-7")
+	rec = httptest.NewRecorder()
+	he.HandleEvents(rec, req)
+	body = rec.Body.String()
+	assert.True(t, strings.HasPrefix(body, "event: reset\ndata: {\"reason\":\"restarted\""), body)
+	assert.Contains(t, body, "id: "+epoch+"-1\n")
+	req = httptest.NewRequest("GET", "/_health/events", nil)
+	req.Header.Set("Last-Event-ID", "7")
+	rec = httptest.NewRecorder()
+	he.HandleEvents(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
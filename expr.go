@@ -0,0 +1,488 @@
+package qcache_health
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+/*
+Small expression language for health rules, e.g.
+
+	count(log)+count(logSkip)+count(logWrongType) == containers.running
+	count(stats) >= 1 && age("docker-logs") < 60
+
+Values are numbers, strings or booleans; the type of each expression is checked while parsing.
+*/
+
+type exprType int
+
+const (
+	exprNumber exprType = iota
+	exprString
+	exprBool
+)
+
+func (t exprType) String() string {
+	switch t {
+	case exprNumber:
+		return "number"
+	case exprString:
+		return "string"
+	}
+	return "bool"
+}
+
+// exprEnv provides the values an expression can reference.
+type exprEnv interface {
+	CountRoutine(routine string) int
+	GetRunningContainers() int
+	GetVital(name string) (Vitals, bool)
+}
+
+type exprNode interface {
+	typ() exprType
+	eval(env exprEnv, t time.Time) (interface{}, error)
+}
+
+// Expr is a parsed and type-checked boolean expression.
+type Expr struct {
+	src 	string
+	root 	exprNode
+}
+
+// ParseExpr parses src and makes sure it evaluates to a boolean.
+func ParseExpr(src string) (*Expr, error) {
+	toks, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	ps := &exprParser{toks: toks}
+	root, err := ps.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if ps.peek().kind != tokEOF {
+		return nil, fmt.Errorf("unexpected '%s' at position %d", ps.peek().val, ps.peek().pos)
+	}
+	if root.typ() != exprBool {
+		return nil, fmt.Errorf("expression has to be a comparison, got %s", root.typ())
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Eval evaluates the expression against env at time t.
+func (e *Expr) Eval(env exprEnv, t time.Time) (bool, error) {
+	v, err := e.root.eval(env, t)
+	if err != nil {
+		return false, err
+	}
+	return v.(bool), nil
+}
+
+/// Lexer
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind 	tokKind
+	val 	string
+	pos 	int
+}
+
+var exprOps = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "+", "-", "*", "/", "!", "(", ")", ","}
+
+func lexExpr(src string) ([]token, error) {
+	toks := []token{}
+	i := 0
+	for i < len(src) {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(src) && (unicode.IsDigit(rune(src[i])) || src[i] == '.') {
+				i++
+			}
+			toks = append(toks, token{tokNumber, src[start:i], start})
+		case c == '"' || c == '\'':
+			start := i
+			end := strings.IndexRune(src[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			toks = append(toks, token{tokString, src[i+1 : i+1+end], start})
+			i += end + 2
+		case unicode.IsLetter(c) || c == '_':
+			start := i
+			for i < len(src) && (unicode.IsLetter(rune(src[i])) || unicode.IsDigit(rune(src[i])) || src[i] == '_' || src[i] == '.') {
+				i++
+			}
+			toks = append(toks, token{tokIdent, src[start:i], start})
+		default:
+			found := false
+			for _, op := range exprOps {
+				if strings.HasPrefix(src[i:], op) {
+					toks = append(toks, token{tokOp, op, i})
+					i += len(op)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unexpected character '%c' at position %d", c, i)
+			}
+		}
+	}
+	return append(toks, token{tokEOF, "end of expression", len(src)}), nil
+}
+
+/// Parser
+
+type exprParser struct {
+	toks 	[]token
+	pos 	int
+}
+
+func (ps *exprParser) peek() token {
+	return ps.toks[ps.pos]
+}
+
+func (ps *exprParser) next() token {
+	t := ps.toks[ps.pos]
+	if t.kind != tokEOF {
+		ps.pos++
+	}
+	return t
+}
+
+func (ps *exprParser) acceptOp(ops ...string) (string, bool) {
+	t := ps.peek()
+	if t.kind != tokOp {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.val == op {
+			ps.next()
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (ps *exprParser) expectOp(op string) error {
+	if _, ok := ps.acceptOp(op); !ok {
+		t := ps.peek()
+		return fmt.Errorf("expected '%s' at position %d, got '%s'", op, t.pos, t.val)
+	}
+	return nil
+}
+
+func (ps *exprParser) parseOr() (exprNode, error) {
+	return ps.parseBinary([]string{"||"}, ps.parseAnd)
+}
+
+func (ps *exprParser) parseAnd() (exprNode, error) {
+	return ps.parseBinary([]string{"&&"}, ps.parseNot)
+}
+
+func (ps *exprParser) parseNot() (exprNode, error) {
+	pos := ps.peek().pos
+	if _, ok := ps.acceptOp("!"); ok {
+		x, err := ps.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if x.typ() != exprBool {
+			return nil, fmt.Errorf("'!' at position %d needs a bool, got %s", pos, x.typ())
+		}
+		return notNode{x}, nil
+	}
+	return ps.parseCmp()
+}
+
+func (ps *exprParser) parseCmp() (exprNode, error) {
+	l, err := ps.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	pos := ps.peek().pos
+	op, ok := ps.acceptOp("==", "!=", "<=", ">=", "<", ">")
+	if !ok {
+		return l, nil
+	}
+	r, err := ps.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	return newBinaryNode(op, l, r, pos)
+}
+
+func (ps *exprParser) parseSum() (exprNode, error) {
+	return ps.parseBinary([]string{"+", "-"}, ps.parseProd)
+}
+
+func (ps *exprParser) parseProd() (exprNode, error) {
+	return ps.parseBinary([]string{"*", "/"}, ps.parseUnary)
+}
+
+func (ps *exprParser) parseBinary(ops []string, operand func() (exprNode, error)) (exprNode, error) {
+	l, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		pos := ps.peek().pos
+		op, ok := ps.acceptOp(ops...)
+		if !ok {
+			return l, nil
+		}
+		r, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		l, err = newBinaryNode(op, l, r, pos)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (ps *exprParser) parseUnary() (exprNode, error) {
+	pos := ps.peek().pos
+	if _, ok := ps.acceptOp("-"); ok {
+		x, err := ps.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return newBinaryNode("-", constNode{0.0}, x, pos)
+	}
+	return ps.parsePrimary()
+}
+
+func (ps *exprParser) parsePrimary() (exprNode, error) {
+	t := ps.next()
+	switch t.kind {
+	case tokNumber:
+		f, err := strconv.ParseFloat(t.val, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%s' at position %d", t.val, t.pos)
+		}
+		return constNode{f}, nil
+	case tokString:
+		return constNode{t.val}, nil
+	case tokOp:
+		if t.val == "(" {
+			x, err := ps.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return x, ps.expectOp(")")
+		}
+	case tokIdent:
+		if _, ok := ps.acceptOp("("); ok {
+			return ps.parseCall(t)
+		}
+		switch t.val {
+		case "true":
+			return constNode{true}, nil
+		case "false":
+			return constNode{false}, nil
+		case "containers.running":
+			return varNode{t.val, exprNumber, func(env exprEnv, _ time.Time) (interface{}, error) {
+				return float64(env.GetRunningContainers()), nil
+			}}, nil
+		}
+		return nil, fmt.Errorf("unknown identifier '%s' at position %d", t.val, t.pos)
+	}
+	return nil, fmt.Errorf("unexpected '%s' at position %d", t.val, t.pos)
+}
+
+// parseCall parses the single name argument of count(), age() and state().
+func (ps *exprParser) parseCall(fn token) (exprNode, error) {
+	arg := ps.next()
+	if arg.kind != tokIdent && arg.kind != tokString {
+		return nil, fmt.Errorf("%s() at position %d expects a name, got '%s'", fn.val, fn.pos, arg.val)
+	}
+	if err := ps.expectOp(")"); err != nil {
+		return nil, err
+	}
+	name := arg.val
+	label := fmt.Sprintf("%s(%s)", fn.val, name)
+	switch fn.val {
+	case "count":
+		return varNode{label, exprNumber, func(env exprEnv, _ time.Time) (interface{}, error) {
+			cnt := env.CountRoutine(name)
+			if cnt < 0 {
+				return 0.0, nil
+			}
+			return float64(cnt), nil
+		}}, nil
+	case "age":
+		return varNode{label, exprNumber, func(env exprEnv, t time.Time) (interface{}, error) {
+			v, ok := env.GetVital(name)
+			if !ok {
+				return nil, fmt.Errorf("vital '%s' not found", name)
+			}
+			return t.Sub(v.LastSign).Seconds(), nil
+		}}, nil
+	case "state":
+		return varNode{label, exprString, func(env exprEnv, _ time.Time) (interface{}, error) {
+			v, ok := env.GetVital(name)
+			if !ok {
+				return nil, fmt.Errorf("vital '%s' not found", name)
+			}
+			return v.LastState, nil
+		}}, nil
+	}
+	return nil, fmt.Errorf("unknown function '%s' at position %d", fn.val, fn.pos)
+}
+
+/// Nodes
+
+type constNode struct {
+	val interface{}
+}
+
+func (n constNode) typ() exprType {
+	switch n.val.(type) {
+	case float64:
+		return exprNumber
+	case string:
+		return exprString
+	}
+	return exprBool
+}
+
+func (n constNode) eval(env exprEnv, t time.Time) (interface{}, error) {
+	return n.val, nil
+}
+
+type varNode struct {
+	name 	string
+	t 		exprType
+	fn 		func(env exprEnv, t time.Time) (interface{}, error)
+}
+
+func (n varNode) typ() exprType {
+	return n.t
+}
+
+func (n varNode) eval(env exprEnv, t time.Time) (interface{}, error) {
+	return n.fn(env, t)
+}
+
+type notNode struct {
+	x exprNode
+}
+
+func (n notNode) typ() exprType {
+	return exprBool
+}
+
+func (n notNode) eval(env exprEnv, t time.Time) (interface{}, error) {
+	v, err := n.x.eval(env, t)
+	if err != nil {
+		return nil, err
+	}
+	return !v.(bool), nil
+}
+
+type binaryNode struct {
+	op 		string
+	l, r 	exprNode
+}
+
+func newBinaryNode(op string, l, r exprNode, pos int) (exprNode, error) {
+	n := binaryNode{op, l, r}
+	lt, rt := l.typ(), r.typ()
+	switch op {
+	case "&&", "||":
+		if lt != exprBool || rt != exprBool {
+			return nil, fmt.Errorf("'%s' at position %d needs bools, got %s and %s", op, pos, lt, rt)
+		}
+	case "==", "!=":
+		if lt != rt {
+			return nil, fmt.Errorf("'%s' at position %d compares %s with %s", op, pos, lt, rt)
+		}
+	default:
+		if lt != exprNumber || rt != exprNumber {
+			return nil, fmt.Errorf("'%s' at position %d needs numbers, got %s and %s", op, pos, lt, rt)
+		}
+	}
+	return n, nil
+}
+
+func (n binaryNode) typ() exprType {
+	switch n.op {
+	case "+", "-", "*", "/":
+		return exprNumber
+	}
+	return exprBool
+}
+
+func (n binaryNode) eval(env exprEnv, t time.Time) (interface{}, error) {
+	l, err := n.l.eval(env, t)
+	if err != nil {
+		return nil, err
+	}
+	// short-circuit, so that e.g. a missing vital can be guarded
+	switch n.op {
+	case "&&":
+		if !l.(bool) {
+			return false, nil
+		}
+	case "||":
+		if l.(bool) {
+			return true, nil
+		}
+	}
+	r, err := n.r.eval(env, t)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "&&", "||":
+		return r.(bool), nil
+	case "==":
+		return l == r, nil
+	case "!=":
+		return l != r, nil
+	}
+	lf, rf := l.(float64), r.(float64)
+	switch n.op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	case "/":
+		if rf == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return lf / rf, nil
+	case "<":
+		return lf < rf, nil
+	case "<=":
+		return lf <= rf, nil
+	case ">":
+		return lf > rf, nil
+	}
+	return lf >= rf, nil
+}
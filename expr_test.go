@@ -0,0 +1,56 @@
+package qcache_health
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"time"
+)
+
+func TestParseExpr_Invalid(t *testing.T) {
+	for _, src := range []string{
+		"count(log)",
+		"count(log) == ",
+		"count(log) == 'a'",
+		"count(log) && true",
+		"unknown == 1",
+		"foo(bar) == 1",
+		"(count(log) == 1",
+		"count(log) == 1)",
+		"state(v1) > 1",
+		"'open == 1",
+		"count(log) # 1",
+	} {
+		_, err := ParseExpr(src)
+		assert.Error(t, err, src)
+	}
+}
+
+func TestExpr_Eval(t *testing.T) {
+	he := NewHealthEndpoint([]string{"log", "logSkip", "stats"})
+	he.AddRoutine("log", rt1)
+	he.AddRoutine("logSkip", rt2)
+	he.SetRunningContainers(2)
+	he.UpsertVitals("docker-logs", "running", ts)
+	now := ts.Add(30 * time.Second)
+	for src, exp := range map[string]bool{
+		"count(log)+count(logSkip)+count(logWrongType) == containers.running": true,
+		"count(stats) >= 1": false,
+		"!(count(stats) >= 1) && true": true,
+		"age('docker-logs') < 60 && state(\"docker-logs\") == 'running'": true,
+		"age(\"docker-logs\") * 2 > 60 || false": false,
+		"-count(log) + 2 * 1.5 == 2": true,
+		"containers.running / 2 != 1": false,
+	} {
+		e, err := ParseExpr(src)
+		assert.NoError(t, err, src)
+		got, err := e.Eval(he, now)
+		assert.NoError(t, err, src)
+		assert.Equal(t, exp, got, src)
+	}
+	e, _ := ParseExpr("age(missing) < 60")
+	_, err := e.Eval(he, now)
+	assert.Error(t, err)
+	e, _ = ParseExpr("count(log) / count(stats) > 1")
+	_, err = e.Eval(he, now)
+	assert.Error(t, err, "Division by zero")
+}
@@ -0,0 +1,94 @@
+package qcache_health
+
+import (
+	"sort"
+	"time"
+)
+
+// SetGracePeriod sets how long a freshly started container is pending, i.e. not expected to be
+// covered by routines yet. A zero duration disables the grace period.
+func (he *HealthEndpoint) SetGracePeriod(d time.Duration) {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	he.gracePeriod = d
+}
+
+func (he *HealthEndpoint) GracePeriod() time.Duration {
+	he.mu.RLock()
+	defer he.mu.RUnlock()
+	return he.gracePeriod
+}
+
+// SetContainerStarts stores the start times of containers (ID -> time), which replace the former ones.
+// Only containers that are not covered by routines (see UncoveredContainers()) need to be provided.
+func (he *HealthEndpoint) SetContainerStarts(starts map[string]time.Time) {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	he.started = map[string]time.Time{}
+	for id, t := range starts {
+		he.started[shortID(id)] = t
+	}
+}
+
+// UncoveredContainers returns the IDs of running containers lacking a routine in at least one group,
+// disregarding the grace period.
+func (he *HealthEndpoint) UncoveredContainers() []string {
+	he.mu.RLock()
+	defer he.mu.RUnlock()
+	now := time.Now()
+	res := []string{}
+	for id := range he.containers {
+		for _, typs := range he.groups {
+			if !he.covered(id, typs, now) {
+				res = append(res, id)
+				break
+			}
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (he *HealthEndpoint) covered(id string, typs []string, t time.Time) bool {
+	for _, typ := range typs {
+		for _, x := range he.activeRoutines(typ, t) {
+			if x == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isPending reports whether the container started less than the grace period before t.
+func (he *HealthEndpoint) isPending(id string, t time.Time) bool {
+	if he.gracePeriod <= 0 {
+		return false
+	}
+	start, ok := he.started[id]
+	return ok && t.Sub(start) < he.gracePeriod
+}
+
+// GetPending returns the running containers within their grace period as 'name(id)'.
+func (he *HealthEndpoint) GetPending() []string {
+	he.mu.RLock()
+	defer he.mu.RUnlock()
+	return he.getPending(time.Now())
+}
+
+func (he *HealthEndpoint) getPending(t time.Time) []string {
+	res := []string{}
+	for id := range he.containers {
+		if he.isPending(id, t) {
+			res = append(res, he.containerRef(id))
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// PendingCount returns the number of pending containers without routine in the group, which the
+// count based checkers subtract from the running containers.
+func (he *HealthEndpoint) PendingCount(group string) int {
+	return len(he.GetDiscrepancies()[group].Pending)
+}
@@ -0,0 +1,46 @@
+package qcache_health
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"time"
+)
+
+func TestHealthEndpoint_GracePeriod(t *testing.T) {
+	he := NewHealthEndpoint([]string{"stats"})
+	he.SetRoutineGroups(map[string][]string{"stats": {"stats"}})
+	he.SetContainers(map[string]string{"aaaaaaaaaaaa0000": "web", "bbbbbbbbbbbb0000": "db"})
+	he.AddRoutine("stats", NewRoutine("bbbbbbbbbbbb", "start", ts))
+	assert.Equal(t, []string{"aaaaaaaaaaaa"}, he.UncoveredContainers())
+	he.SetContainerStarts(map[string]time.Time{"aaaaaaaaaaaa0000": ts})
+	assert.Equal(t, []string{}, he.getPending(ts.Add(time.Second)), "Grace period is disabled by default")
+	he.SetGracePeriod(time.Minute)
+	d := he.getDiscrepancies(ts.Add(time.Second))["stats"]
+	assert.Equal(t, []string{}, d.Missing)
+	assert.Equal(t, []string{"web(aaaaaaaaaaaa)"}, d.Pending)
+	assert.True(t, d.Empty())
+	assert.Equal(t, []string{"web(aaaaaaaaaaaa)"}, he.getPending(ts.Add(time.Second)))
+	assert.Equal(t, []string{"~stats         : | pending:web(aaaaaaaaaaaa)"}, he.getDiscrepanciesTXT(ts.Add(time.Second)))
+	assert.Equal(t, []string{"web(aaaaaaaaaaaa)"}, he.getJSON(ts.Add(time.Second))["pending"])
+	d = he.getDiscrepancies(ts.Add(time.Minute))["stats"]
+	assert.Equal(t, []string{"web(aaaaaaaaaaaa)"}, d.Missing, "Grace period is over")
+	assert.Equal(t, []string{}, d.Pending)
+	assert.False(t, strings.Contains(strings.Join(he.getDiscrepanciesTXT(ts.Add(time.Minute)), "\n"), "pending"))
+}
+
+func TestStatsChecker_Pending(t *testing.T) {
+	he := NewHealthEndpoint([]string{"stats"})
+	he.SetRoutineGroups(map[string][]string{"stats": {"stats"}})
+	he.SetContainers(map[string]string{"aaaaaaaaaaaa0000": "web", "bbbbbbbbbbbb0000": "db"})
+	he.AddRoutine("stats", NewRoutine("bbbbbbbbbbbb", "start", ts))
+	he.SetGracePeriod(time.Minute)
+	he.SetContainerStarts(map[string]time.Time{"aaaaaaaaaaaa": time.Now()})
+	res := NewStatsChecker().Check(he)
+	assert.Equal(t, Healthy, res.Status)
+	assert.Equal(t, 1, res.Details["pending"])
+	he.SetContainerStarts(map[string]time.Time{"aaaaaaaaaaaa": time.Now().Add(-time.Hour)})
+	res = NewStatsChecker().Check(he)
+	assert.Equal(t, Unhealthy, res.Status)
+	assert.Equal(t, "metricsGoRoutines:1 | stats without routine:[web(aaaaaaaaaaaa)]", res.Message)
+}
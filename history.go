@@ -0,0 +1,130 @@
+package qcache_health
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	gring "github.com/zfjagann/golang-ring"
+)
+
+const (
+	defaultHistoryCapacity = 100
+)
+
+// HealthEntry is a status set at a certain point in time, repeated Count times until LastSeen.
+type HealthEntry struct {
+	Status 	string
+	Message string
+	Time 	time.Time
+	Count 	int
+	LastSeen time.Time
+}
+
+func NewHealthEntry(status, msg string, t time.Time) HealthEntry {
+	return HealthEntry{
+		Status: status,
+		Message: msg,
+		Time: t,
+		Count: 1,
+		LastSeen: t,
+	}
+}
+
+func (e HealthEntry) GetJSON() map[string]interface{} {
+	return map[string]interface{}{
+		"status": e.Status,
+		"message": e.Message,
+		"time": e.Time.Format(time.RFC3339Nano),
+		"count": e.Count,
+		"last_seen": e.LastSeen.Format(time.RFC3339Nano),
+	}
+}
+
+// SetHistoryCapacity changes how many status entries are kept, keeping the most recent ones.
+// The capacity never drops below ringCapacity, which is needed to evaluate transitions.
+func (he *HealthEndpoint) SetHistoryCapacity(size int) {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	if size < ringCapacity {
+		size = ringCapacity
+	}
+	entries := he.healthEntries()
+	if len(entries) > size {
+		entries = entries[len(entries)-size:]
+	}
+	r := &gring.Ring{}
+	r.SetCapacity(size)
+	for i := range entries {
+		r.Enqueue(&entries[i])
+	}
+	he.healthRing = r
+}
+
+// GetHistory returns the entries last seen after since (if not zero) in chronological order,
+// limited to the most recent limit entries (if greater than zero).
+func (he *HealthEndpoint) GetHistory(since time.Time, limit int) []HealthEntry {
+	he.mu.RLock()
+	defer he.mu.RUnlock()
+	res := []HealthEntry{}
+	for _, e := range he.healthEntries() {
+		if !since.IsZero() && !e.LastSeen.After(since) {
+			continue
+		}
+		res = append(res, e)
+	}
+	if limit > 0 && len(res) > limit {
+		res = res[len(res)-limit:]
+	}
+	return res
+}
+
+func (he *HealthEndpoint) healthEntries() []HealthEntry {
+	res := []HealthEntry{}
+	for _, e := range he.healthRing.Values() {
+		res = append(res, *e.(*HealthEntry))
+	}
+	return res
+}
+
+// lastHealthEntry returns the current entry of the ring, which is updated in place while it repeats.
+func (he *HealthEndpoint) lastHealthEntry() *HealthEntry {
+	values := he.healthRing.Values()
+	return values[len(values)-1].(*HealthEntry)
+}
+
+// HandleHistory serves the status history, filtered by the optional query parameters
+// 'since' (RFC3339 timestamp or a duration like '15m') and 'limit'.
+func (he *HealthEndpoint) HandleHistory(w http.ResponseWriter, req *http.Request) {
+	since, limit, err := parseHistoryQuery(req, time.Now())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	res := []map[string]interface{}{}
+	for _, e := range he.GetHistory(since, limit) {
+		res = append(res, e.GetJSON())
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func parseHistoryQuery(req *http.Request, now time.Time) (since time.Time, limit int, err error) {
+	q := req.URL.Query()
+	if s := q.Get("since"); s != "" {
+		since, err = time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			d, dErr := time.ParseDuration(s)
+			if dErr != nil {
+				return since, limit, fmt.Errorf("Could not parse since '%s' as RFC3339 timestamp or duration", s)
+			}
+			since, err = now.Add(-d), nil
+		}
+	}
+	if l := q.Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 0 {
+			return since, limit, fmt.Errorf("Could not parse limit '%s' as positive integer", l)
+		}
+	}
+	return
+}
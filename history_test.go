@@ -0,0 +1,67 @@
+package qcache_health
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"time"
+	"net/http"
+	"net/http/httptest"
+)
+
+func TestHealthEndpoint_GetHistory(t *testing.T) {
+	he := NewHealthEndpoint([]string{})
+	he.SetHistoryCapacity(4)
+	he.setHealth(Healthy, "I am fine", ts)
+	he.setHealth(Unhealthy, "some error", ts.Add(time.Minute))
+	he.setHealth(Healthy, "I am fine", ts.Add(2*time.Minute))
+	he.setHealth(Healthy, "still fine", ts.Add(3*time.Minute))
+	got := he.GetHistory(time.Time{}, 0)
+	assert.Len(t, got, 4, "Starting entry was pushed out")
+	assert.Equal(t, NewHealthEntry(Healthy, "I am fine", ts), got[0])
+	got = he.GetHistory(ts.Add(time.Minute), 0)
+	assert.Len(t, got, 2)
+	got = he.GetHistory(time.Time{}, 1)
+	assert.Equal(t, []HealthEntry{NewHealthEntry(Healthy, "still fine", ts.Add(3*time.Minute))}, got)
+	he.SetHistoryCapacity(1)
+	assert.Len(t, he.GetHistory(time.Time{}, 0), ringCapacity, "Never shrinks below ringCapacity")
+}
+
+func TestParseHistoryQuery(t *testing.T) {
+	now := time.Now()
+	since, limit, err := parseHistoryQuery(httptest.NewRequest("GET", "/_health/history?since=15m&limit=2", nil), now)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(-15*time.Minute), since)
+	assert.Equal(t, 2, limit)
+	since, _, err = parseHistoryQuery(httptest.NewRequest("GET", "/_health/history?since=2017-09-20T17:16:02Z", nil), now)
+	assert.NoError(t, err)
+	assert.Equal(t, ts.Unix(), since.Unix())
+	_, _, err = parseHistoryQuery(httptest.NewRequest("GET", "/_health/history?since=yesterday", nil), now)
+	assert.Error(t, err)
+	_, _, err = parseHistoryQuery(httptest.NewRequest("GET", "/_health/history?limit=-1", nil), now)
+	assert.Error(t, err)
+}
+
+func TestHealthEndpoint_HandleHistory(t *testing.T) {
+	he := NewHealthEndpoint([]string{})
+	rec := httptest.NewRecorder()
+	he.HandleHistory(rec, httptest.NewRequest("GET", "/_health/history", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "\"status\":\"starting\"")
+	rec = httptest.NewRecorder()
+	he.HandleHistory(rec, httptest.NewRequest("GET", "/_health/history?limit=x", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestHealthEndpoint_GetHistoryCollapsed(t *testing.T) {
+	he := NewHealthEndpoint([]string{})
+	he.setHealth(Healthy, "I am fine", ts)
+	he.setHealth(Healthy, "I am fine", ts.Add(time.Minute))
+	he.setHealth(Healthy, "I am fine", ts.Add(2*time.Minute))
+	he.setHealth(Healthy, "still fine", ts.Add(3*time.Minute))
+	got := he.GetHistory(time.Time{}, 0)
+	assert.Len(t, got, 3, "Repeated evaluations are collapsed")
+	assert.Equal(t, 3, got[1].Count)
+	assert.Equal(t, ts, got[1].Time)
+	assert.Equal(t, ts.Add(2*time.Minute), got[1].LastSeen)
+	assert.Contains(t, he.GetHistory(ts.Add(90*time.Second), 0), got[1], "Filtered by the time last seen")
+}
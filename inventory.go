@@ -0,0 +1,85 @@
+package qcache_health
+
+import (
+	"fmt"
+	"time"
+	"github.com/docker/docker/api/types"
+	"github.com/qframe/types/docker-events"
+)
+
+// containerInventory holds the running containers, seeded by ContainerList() and kept up to date
+// by ContainerEvents in between full resyncs.
+type containerInventory struct {
+	cnts 		map[string]types.Container
+	syncedAt 	time.Time
+	// eventDriven is set with the first ContainerEvent, until then every tick compares the counts of Info()
+	eventDriven bool
+}
+
+// resyncDue reports whether the inventory has to be fetched from the engine, which happens
+// every 'inventory-resync-ms'. As long as no ContainerEvents keep it up to date in between, it is
+// also due once the running and paused containers of Info() differ from the inventory.
+func (p *Plugin) resyncDue(e *dockerEngine, t time.Time) (bool, error) {
+	resync := time.Duration(p.CfgIntOr("inventory-resync-ms", 60000))*time.Millisecond
+	if e.inventory.cnts == nil || t.Sub(e.inventory.syncedAt) >= resync {
+		return true, nil
+	}
+	if e.inventory.eventDriven {
+		return false, nil
+	}
+	c, cancel := p.dockerCtx()
+	defer cancel()
+	info, err := e.cli.Info(c)
+	if err != nil {
+		return false, fmt.Errorf("Info(): %s", err)
+	}
+	return info.ContainersRunning+info.ContainersPaused != len(e.inventory.cnts), nil
+}
+
+// syncInventory replaces the inventory with the running containers listed by the engine.
+func (p *Plugin) syncInventory(e *dockerEngine, t time.Time) (err error) {
+	c, cancel := p.dockerCtx()
+	defer cancel()
+	cnts, err := e.cli.ContainerList(c, types.ContainerListOptions{})
+	if err != nil {
+		return fmt.Errorf("ContainerList(): %s", err)
+	}
+	e.inventory.cnts = map[string]types.Container{}
+	for _, cnt := range cnts {
+		e.inventory.cnts[cnt.ID] = cnt
+	}
+	e.inventory.syncedAt = t
+	return
+}
+
+// resetInventory forces a resync with the next tick, e.g. after the engine was unreachable.
+func (e *dockerEngine) resetInventory() {
+	e.inventory.cnts = nil
+}
+
+// updateInventory applies a ContainerEvent to the inventory.
+func (e *dockerEngine) updateInventory(id, name string, ce qtypes_docker_events.ContainerEvent) {
+	e.inventory.eventDriven = true
+	if e.inventory.cnts == nil {
+		return
+	}
+	switch ce.Event.Action {
+	case "start", "unpause":
+		cnt := types.Container{ID: id, Names: []string{"/" + name}, Labels: ce.Event.Actor.Attributes, State: "running"}
+		if ce.Container.Config != nil {
+			cnt.Labels = ce.Container.Config.Labels
+		}
+		if prev, ok := e.inventory.cnts[id]; ok && ce.Event.Action == "unpause" {
+			cnt = prev
+			cnt.State = "running"
+		}
+		e.inventory.cnts[id] = cnt
+	case "pause":
+		if cnt, ok := e.inventory.cnts[id]; ok {
+			cnt.State = "paused"
+			e.inventory.cnts[id] = cnt
+		}
+	case "die", "destroy":
+		delete(e.inventory.cnts, id)
+	}
+}
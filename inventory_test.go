@@ -0,0 +1,77 @@
+package qcache_health
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/qframe/types/docker-events"
+	"github.com/qframe/types/messages"
+	"time"
+)
+
+func containerEvent(action, id, name string, labels map[string]string) qtypes_docker_events.ContainerEvent {
+	msg := events.Message{Type: "container", Action: action, Actor: events.Actor{ID: id, Attributes: map[string]string{"name": name}}}
+	de := qtypes_docker_events.NewDockerEvent(qtypes_messages.NewBase("test"), msg)
+	cnt := types.ContainerJSON{}
+	if action == "start" {
+		cnt = types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{ID: id, Name: "/" + name},
+			Config: &container.Config{Labels: labels},
+		}
+	}
+	return qtypes_docker_events.NewContainerEvent(de, cnt)
+}
+
+func TestPlugin_inventory(t *testing.T) {
+	srv := fakeDocker(t, []types.Container{{ID: "aaaaaaaaaaaa0000", Names: []string{"/web"}}})
+	defer srv.Close()
+	p := newDockerPlugin(t, srv, map[string]string{"log.level": "error", "cache.test.select.exclude-labels": "infra"})
+	due, _ := p.resyncDue(p.engines[0], time.Now())
+	assert.True(t, due)
+	assert.Equal(t, 1, p.getRunningCntCount())
+	due, err := p.resyncDue(p.engines[0], time.Now())
+	assert.NoError(t, err)
+	assert.False(t, due, "Info() reports the listed number of containers")
+	p.engines[0].inventory.cnts = map[string]types.Container{}
+	due, _ = p.resyncDue(p.engines[0], time.Now())
+	assert.True(t, due, "Info() differs from the inventory without ContainerEvents")
+	p.getRunningCntCount()
+	p.handleContainerEvent(containerEvent("start", "bbbbbbbbbbbb0000", "db", nil))
+	p.handleContainerEvent(containerEvent("start", "cccccccccccc0000", "collector", map[string]string{"infra": "true"}))
+	due, _ = p.resyncDue(p.engines[0], time.Now())
+	assert.False(t, due, "Info() is not asked once ContainerEvents arrive")
+	due, _ = p.resyncDue(p.engines[0], time.Now().Add(time.Minute))
+	assert.True(t, due)
+	srv.Close()
+	assert.Equal(t, 2, p.getRunningCntCount(), "Evaluated against the inventory without calling the engine")
+	assert.Equal(t, map[string]string{"aaaaaaaaaaaa": "web", "bbbbbbbbbbbb": "db"}, p.HealthEndpoint.containers)
+	p.handleContainerEvent(containerEvent("pause", "bbbbbbbbbbbb0000", "db", nil))
+	assert.Equal(t, 2, p.getRunningCntCount(), "Paused containers are still running")
+	assert.Equal(t, "paused", p.engines[0].inventory.cnts["bbbbbbbbbbbb0000"].State)
+	p.handleContainerEvent(containerEvent("unpause", "bbbbbbbbbbbb0000", "db", nil))
+	assert.Equal(t, "running", p.engines[0].inventory.cnts["bbbbbbbbbbbb0000"].State)
+	p.handleContainerEvent(containerEvent("die", "aaaaaaaaaaaa0000", "web", nil))
+	assert.Equal(t, 1, p.getRunningCntCount())
+}
+
+func TestPlugin_inventoryInfoFailed(t *testing.T) {
+	srv := fakeDocker(t, []types.Container{{ID: "aaaaaaaaaaaa0000", Names: []string{"/web"}}})
+	defer srv.Close()
+	p := newDockerPlugin(t, srv, map[string]string{"log.level": "error"})
+	assert.Equal(t, 1, p.getRunningCntCount())
+	srv.Close()
+	assert.Equal(t, -1, p.getRunningCntCount(), "A failing Info() makes the engine unreachable")
+	assert.False(t, p.HealthEndpoint.IsDockerReachable())
+}
+
+func TestPlugin_resyncDue(t *testing.T) {
+	p := newCfgPlugin(t, map[string]string{"log.level": "error", "cache.test.inventory-resync-ms": "0"})
+	p.engines[0].inventory = containerInventory{cnts: map[string]types.Container{}, syncedAt: time.Now(), eventDriven: true}
+	due, err := p.resyncDue(p.engines[0], time.Now())
+	assert.NoError(t, err)
+	assert.True(t, due)
+	p.engines[0].resetInventory()
+	assert.Nil(t, p.engines[0].inventory.cnts)
+}
@@ -0,0 +1,186 @@
+package qcache_health
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	RoutineStarting = "starting"
+	RoutineRunning = "running"
+	RoutinePaused = "paused"
+	RoutineRestarting = "restarting"
+	RoutineStopped = "stopped"
+	RoutineFailed = "failed"
+	routineHistoryCapacity = 20
+	// stoppedCapacity bounds the number of stopped routines kept per type
+	stoppedCapacity = 100
+)
+
+var (
+	// routineActions maps the actions of routine.* HealthBeats to the state they lead to
+	routineActions = map[string]string{
+		"starting": RoutineStarting,
+		"start": RoutineRunning,
+		"resume": RoutineRunning,
+		"pause": RoutinePaused,
+		"restart": RoutineRestarting,
+		"stop": RoutineStopped,
+		"fail": RoutineFailed,
+	}
+	// routineTransitions lists the states reachable from a state, the empty state being an unknown routine
+	routineTransitions = map[string][]string{
+		"": {RoutineStarting, RoutineRunning},
+		RoutineStarting: {RoutineRunning, RoutineStopped, RoutineFailed},
+		RoutineRunning: {RoutinePaused, RoutineRestarting, RoutineStopped, RoutineFailed},
+		RoutinePaused: {RoutineRunning, RoutineRestarting, RoutineStopped, RoutineFailed},
+		RoutineRestarting: {RoutineStarting, RoutineRunning, RoutineStopped, RoutineFailed},
+		RoutineFailed: {RoutineStarting, RoutineRunning, RoutineRestarting, RoutineStopped},
+	}
+)
+
+// IsRoutineAction reports whether the action of a routine.* HealthBeat is understood, 'beat' included.
+func IsRoutineAction(action string) bool {
+	_, ok := routineActions[action]
+	return ok || action == "beat"
+}
+
+func validTransition(from, to string) bool {
+	for _, s := range routineTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// errInvalidTransition is returned by RoutineAction if the action does not fit the state of the routine.
+type errInvalidTransition struct {
+	reason 	string
+}
+
+func (e errInvalidTransition) Error() string {
+	return e.reason
+}
+
+func isInvalidTransition(err error) bool {
+	_, ok := err.(errInvalidTransition)
+	return ok
+}
+
+// RoutineTransition is a state change of a routine.
+type RoutineTransition struct {
+	From 	string
+	To 		string
+	Action 	string
+	Time 	time.Time
+}
+
+func (rt RoutineTransition) GetJSON() map[string]interface{} {
+	return map[string]interface{}{
+		"from": rt.From,
+		"to": rt.To,
+		"action": rt.Action,
+		"time": rt.Time.Format(time.RFC3339Nano),
+	}
+}
+
+// RoutineAction applies the action of a routine.* HealthBeat to the routine with the ID of rt.
+// Routines are added by 'start' or 'starting' and removed by 'stop'; 'beat' only refreshes the last beat.
+// Stopped routines are kept with their history, which is continued once the ID is added again.
+// Invalid transitions (e.g. stop without start or a double start) are counted per routine type.
+func (he *HealthEndpoint) RoutineAction(routineType, action string, rt Routine) (err error) {
+	if action == "beat" {
+		return he.BeatRoutine(routineType, rt)
+	}
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	r, ok := he.goRoutines[routineType]
+	if !ok {
+		return fmt.Errorf("Could not find routine type '%s'", routineType)
+	}
+	to, ok := routineActions[action]
+	if !ok {
+		return fmt.Errorf("Could not handle action '%s' of routine '%s'", action, rt.GetID())
+	}
+	t := rt.updated
+	cur, exists := r.GetRoutine(rt.GetID())
+	if !validTransition(cur.state, to) {
+		he.invalidTransitions[routineType]++
+		from := cur.state
+		if !exists {
+			from = "unknown"
+		}
+		return errInvalidTransition{fmt.Sprintf("Invalid transition of routine '%s' of type '%s' from '%s' to '%s' by action '%s'", rt.GetID(), routineType, from, to, action)}
+	}
+	switch {
+	case !exists:
+		he.restoreHistory(routineType, &rt)
+		rt.transition(to, action, t)
+		r.Add(rt)
+		he.publishRoutine(routineType, "add", rt, t)
+	case to == RoutineStopped:
+		r.Del(cur)
+		cur.transition(to, action, t)
+		he.keepStopped(routineType, cur)
+		he.publishRoutine(routineType, "del", cur, t)
+	default:
+		cur.transition(to, action, t)
+		r.set(cur)
+		he.publishRoutine(routineType, "update", cur, t)
+	}
+	return
+}
+
+// keepStopped keeps a stopped routine along with its history, dropping the one stopped the longest ago
+// once more than stoppedCapacity routines of the type are kept.
+func (he *HealthEndpoint) keepStopped(routineType string, rt Routine) {
+	s, ok := he.stopped[routineType]
+	if !ok {
+		s = map[string]Routine{}
+		he.stopped[routineType] = s
+	}
+	s[rt.GetID()] = rt
+	if len(s) <= stoppedCapacity {
+		return
+	}
+	oldest := ""
+	for id, x := range s {
+		if oldest == "" || x.updated.Before(s[oldest].updated) {
+			oldest = id
+		}
+	}
+	delete(s, oldest)
+}
+
+// restoreHistory continues the history of a previously stopped routine with the ID of rt.
+func (he *HealthEndpoint) restoreHistory(routineType string, rt *Routine) {
+	x, ok := he.stopped[routineType][rt.GetID()]
+	if !ok {
+		return
+	}
+	delete(he.stopped[routineType], rt.GetID())
+	rt.history = x.history
+	rt.state = x.state
+}
+
+// getStopped returns the kept stopped routine of the type by ID.
+func (he *HealthEndpoint) getStopped(routineType, id string) (Routine, bool) {
+	rt, ok := he.stopped[routineType][id]
+	return rt, ok
+}
+
+// GetInvalidTransitions returns the number of invalid routine transitions per routine type.
+func (he *HealthEndpoint) GetInvalidTransitions() map[string]int {
+	he.mu.RLock()
+	defer he.mu.RUnlock()
+	return he.getInvalidTransitions()
+}
+
+func (he *HealthEndpoint) getInvalidTransitions() map[string]int {
+	res := map[string]int{}
+	for k, v := range he.invalidTransitions {
+		res[k] = v
+	}
+	return res
+}
@@ -0,0 +1,94 @@
+package qcache_health
+
+import (
+	"fmt"
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"time"
+)
+
+func TestHealthEndpoint_RoutineAction(t *testing.T) {
+	he := NewHealthEndpoint([]string{"log"})
+	assert.Error(t, he.RoutineAction("log", "stop", NewRoutine("id1", "stop", ts)), "Stop without start")
+	assert.NoError(t, he.RoutineAction("log", "starting", NewRoutine("id1", "starting", ts)))
+	assert.NoError(t, he.RoutineAction("log", "start", NewRoutine("id1", "start", ts.Add(time.Second))))
+	err := he.RoutineAction("log", "start", NewRoutine("id1", "start", ts.Add(2*time.Second)))
+	assert.Equal(t, "Invalid transition of routine 'id1' of type 'log' from 'running' to 'running' by action 'start'", err.Error())
+	assert.True(t, isInvalidTransition(err))
+	assert.NoError(t, he.RoutineAction("log", "pause", NewRoutine("id1", "pause", ts.Add(3*time.Second))))
+	assert.Equal(t, 1, he.CountRoutine("log"), "Paused routines are counted")
+	assert.NoError(t, he.RoutineAction("log", "fail", NewRoutine("id1", "fail", ts.Add(4*time.Second))))
+	assert.Equal(t, 0, he.CountRoutine("log"), "Failed routines are not counted")
+	assert.Error(t, he.RoutineAction("log", "bounce", NewRoutine("id1", "bounce", ts)))
+	assert.Error(t, he.RoutineAction("foo", "start", NewRoutine("id1", "start", ts)))
+	assert.Equal(t, map[string]int{"log": 2}, he.GetInvalidTransitions())
+	assert.Equal(t, map[string]int{"log": 2}, he.GetJSON()["invalid_transitions"])
+	rt, ok := he.goRoutines["log"].GetRoutine("id1")
+	assert.True(t, ok)
+	assert.Equal(t, RoutineFailed, rt.GetState())
+	exp := []RoutineTransition{
+		{From: "", To: RoutineStarting, Action: "starting", Time: ts},
+		{From: RoutineStarting, To: RoutineRunning, Action: "start", Time: ts.Add(time.Second)},
+		{From: RoutineRunning, To: RoutinePaused, Action: "pause", Time: ts.Add(3 * time.Second)},
+		{From: RoutinePaused, To: RoutineFailed, Action: "fail", Time: ts.Add(4 * time.Second)},
+	}
+	assert.Equal(t, exp, rt.GetHistory())
+	_, res := he.getRoutinesJSON([]string{"log", "id1"}, ts)
+	assert.Len(t, res.(map[string]interface{})["history"], 4)
+	assert.NoError(t, he.RoutineAction("log", "stop", NewRoutine("id1", "stop", ts.Add(5*time.Second))))
+	assert.Equal(t, "", he.goRoutines["log"].String())
+}
+
+func TestRoutine_HistoryBounded(t *testing.T) {
+	rt := NewRoutine("id1", "start", ts)
+	for i := 0; i < routineHistoryCapacity+5; i++ {
+		rt.transition(RoutineRunning, "start", ts.Add(time.Duration(i)*time.Second))
+	}
+	h := rt.GetHistory()
+	assert.Len(t, h, routineHistoryCapacity)
+	assert.Equal(t, ts.Add(time.Duration(routineHistoryCapacity+4)*time.Second), h[len(h)-1].Time)
+}
+
+func TestHealthEndpoint_RoutineActionRestart(t *testing.T) {
+	he := NewHealthEndpoint([]string{"log"})
+	assert.NoError(t, he.RoutineAction("log", "start", NewRoutine("id1", "start", ts)))
+	assert.NoError(t, he.RoutineAction("log", "stop", NewRoutine("id1", "stop", ts.Add(time.Second))))
+	assert.NoError(t, he.RoutineAction("log", "start", NewRoutine("id1", "start", ts.Add(2*time.Second))))
+	rt, _ := he.goRoutines["log"].GetRoutine("id1")
+	exp := []RoutineTransition{
+		{From: "", To: RoutineRunning, Action: "start", Time: ts},
+		{From: RoutineRunning, To: RoutineStopped, Action: "stop", Time: ts.Add(time.Second)},
+		{From: RoutineStopped, To: RoutineRunning, Action: "start", Time: ts.Add(2 * time.Second)},
+	}
+	assert.Equal(t, exp, rt.GetHistory(), "The history continues after a restart")
+	code, _ := he.getRoutinesJSON([]string{"log", "id1"}, ts)
+	assert.Equal(t, 200, code)
+	_, _, c := he.Events().Subscribe("", 0)
+	defer he.Events().Unsubscribe(c)
+	assert.NoError(t, he.RoutineAction("log", "stop", NewRoutine("id1", "stop", ts.Add(time.Minute))))
+	e := <-c
+	assert.Equal(t, "del", e.Data["action"])
+	assert.Equal(t, "58s", e.Data["uptime"], "Final uptime since the restart")
+	code, res := he.getRoutinesJSON([]string{"log", "id1"}, ts.Add(time.Hour))
+	assert.Equal(t, 200, code, "Stopped routines are served")
+	rj := res.(map[string]interface{})
+	assert.Equal(t, RoutineStopped, rj["state"])
+	assert.Equal(t, "58s", rj["uptime"])
+	assert.Equal(t, false, rj["stale"])
+	assert.Len(t, rj["history"], 4)
+	assert.Equal(t, 0, he.CountRoutine("log"))
+	for i := 0; i <= stoppedCapacity; i++ {
+		id := fmt.Sprintf("id%d", i+2)
+		he.RoutineAction("log", "start", NewRoutine(id, "start", ts.Add(time.Duration(i)*time.Second)))
+		he.RoutineAction("log", "stop", NewRoutine(id, "stop", ts.Add(time.Duration(i)*time.Second)))
+	}
+	assert.Len(t, he.stopped["log"], stoppedCapacity)
+	assert.NotContains(t, he.stopped["log"], "id2", "The routine stopped the longest ago is dropped")
+}
+
+func TestIsRoutineAction(t *testing.T) {
+	for _, a := range []string{"starting", "start", "resume", "pause", "restart", "stop", "fail", "beat"} {
+		assert.True(t, IsRoutineAction(a), a)
+	}
+	assert.False(t, IsRoutineAction("running"))
+}
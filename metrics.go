@@ -0,0 +1,134 @@
+package qcache_health
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	metricsPrefix = "qframe_health"
+	metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+var (
+	// healthStates are always exported, so that a missing series does not hide a status change
+	healthStates = []string{Starting, Healthy, Degraded, Unhealthy}
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// GetMetrics returns the current state in the Prometheus text exposition format.
+func (he *HealthEndpoint) GetMetrics() string {
+	he.mu.RLock()
+	defer he.mu.RUnlock()
+	return he.getMetrics(time.Now())
+}
+
+func (he *HealthEndpoint) getMetrics(t time.Time) string {
+	res := []string{}
+	// Routines
+	res = append(res, metricHeader("routines", "gauge", "Number of active routines registered per routine type."))
+	keys := []string{}
+	for k := range he.goRoutines {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	stale := []string{}
+	for _, k := range keys {
+		a, s := he.splitRoutines(k, t)
+		res = append(res, metricLine("routines", map[string]string{"type": k}, float64(len(a))))
+		stale = append(stale, metricLine("stale_routines", map[string]string{"type": k}, float64(len(s))))
+	}
+	res = append(res, metricHeader("stale_routines", "gauge", "Number of routines without a beat within the max silence per routine type."))
+	res = append(res, stale...)
+	// Rejected beats
+	res = append(res, metricHeader("rejected_beats_total", "counter", "Number of HealthBeats dropped per beat type."))
+	keys = []string{}
+	for k := range he.rejectedBeats {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		res = append(res, metricLine("rejected_beats_total", map[string]string{"type": k}, float64(he.rejectedBeats[k])))
+	}
+	// Invalid routine transitions
+	res = append(res, metricHeader("invalid_transitions_total", "counter", "Number of invalid routine transitions per routine type."))
+	keys = []string{}
+	for k := range he.invalidTransitions {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		res = append(res, metricLine("invalid_transitions_total", map[string]string{"type": k}, float64(he.invalidTransitions[k])))
+	}
+	// Containers
+	res = append(res, metricHeader("running_containers", "gauge", "Number of running containers reported by the docker engine (-1 if unknown)."))
+	res = append(res, metricLine("running_containers", nil, float64(he.cntCount)))
+	res = append(res, metricHeader("docker_reachable", "gauge", "Whether the last call to the docker engine succeeded."))
+	if _, ok := he.engines[""]; ok || len(he.engines) == 0 {
+		reachable := 1.0
+		if he.engines[""].err != "" {
+			reachable = 0.0
+		}
+		res = append(res, metricLine("docker_reachable", nil, reachable))
+	}
+	for _, n := range he.namedEngines() {
+		reachable := 1.0
+		if he.engines[n].err != "" {
+			reachable = 0.0
+		}
+		res = append(res, metricLine("docker_reachable", map[string]string{"engine": n}, reachable))
+	}
+	// Health status
+	hStatus, _ := he.currentHealth()
+	res = append(res, metricHeader("status", "gauge", "Current health status, the active status is set to 1."))
+	for _, s := range healthStates {
+		val := 0.0
+		if s == hStatus {
+			val = 1.0
+		}
+		res = append(res, metricLine("status", map[string]string{"status": s}, val))
+	}
+	// Vitals
+	res = append(res, metricHeader("vital_age_seconds", "gauge", "Seconds since the last sign of a vital."))
+	keys = []string{}
+	for k := range he.vitals {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		age := t.Sub(he.vitals[k].LastSign).Seconds()
+		res = append(res, metricLine("vital_age_seconds", map[string]string{"vital": k}, age))
+	}
+	return strings.Join(append(res, ""), "\n")
+}
+
+// HandleMetrics serves GetMetrics() to be scraped by Prometheus.
+func (he *HealthEndpoint) HandleMetrics(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", metricsContentType)
+	fmt.Fprint(w, he.GetMetrics())
+}
+
+func metricHeader(name, typ, help string) string {
+	fqName := fmt.Sprintf("%s_%s", metricsPrefix, name)
+	return fmt.Sprintf("# HELP %s %s\n# TYPE %s %s", fqName, help, fqName, typ)
+}
+
+func metricLine(name string, labels map[string]string, val float64) string {
+	fqName := fmt.Sprintf("%s_%s", metricsPrefix, name)
+	if len(labels) == 0 {
+		return fmt.Sprintf("%s %g", fqName, val)
+	}
+	keys := []string{}
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	lbls := []string{}
+	for _, k := range keys {
+		lbls = append(lbls, fmt.Sprintf("%s=\"%s\"", k, labelEscaper.Replace(labels[k])))
+	}
+	return fmt.Sprintf("%s{%s} %g", fqName, strings.Join(lbls, ","), val)
+}
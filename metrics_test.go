@@ -0,0 +1,71 @@
+package qcache_health
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"time"
+	"net/http/httptest"
+)
+
+func TestHealthEndpoint_GetMetrics(t *testing.T) {
+	he := NewHealthEndpoint([]string{"log", "stats"})
+	err := he.AddRoutine("log", rt1)
+	assert.NoError(t, err)
+	err = he.AddRoutine("log", rt2)
+	assert.NoError(t, err)
+	assert.Error(t, he.AddRoutine("log", rt2))
+	he.SetRunningContainers(2)
+	he.RejectBeat("routine.foo")
+	he.RejectBeat("routine.foo")
+	now := time.Now()
+	he.UpsertVitals("v1", "init", now)
+	exp := []string{
+		"# HELP qframe_health_routines Number of active routines registered per routine type.",
+		"# TYPE qframe_health_routines gauge",
+		`qframe_health_routines{type="log"} 2`,
+		`qframe_health_routines{type="stats"} 0`,
+		"# HELP qframe_health_stale_routines Number of routines without a beat within the max silence per routine type.",
+		"# TYPE qframe_health_stale_routines gauge",
+		`qframe_health_stale_routines{type="log"} 0`,
+		`qframe_health_stale_routines{type="stats"} 0`,
+		"# HELP qframe_health_rejected_beats_total Number of HealthBeats dropped per beat type.",
+		"# TYPE qframe_health_rejected_beats_total counter",
+		`qframe_health_rejected_beats_total{type="routine.foo"} 2`,
+		"# HELP qframe_health_invalid_transitions_total Number of invalid routine transitions per routine type.",
+		"# TYPE qframe_health_invalid_transitions_total counter",
+		`qframe_health_invalid_transitions_total{type="log"} 1`,
+		"# HELP qframe_health_running_containers Number of running containers reported by the docker engine (-1 if unknown).",
+		"# TYPE qframe_health_running_containers gauge",
+		"qframe_health_running_containers 2",
+		"# HELP qframe_health_docker_reachable Whether the last call to the docker engine succeeded.",
+		"# TYPE qframe_health_docker_reachable gauge",
+		"qframe_health_docker_reachable 1",
+		"# HELP qframe_health_status Current health status, the active status is set to 1.",
+		"# TYPE qframe_health_status gauge",
+		`qframe_health_status{status="starting"} 1`,
+		`qframe_health_status{status="healthy"} 0`,
+		`qframe_health_status{status="degraded"} 0`,
+		`qframe_health_status{status="unhealthy"} 0`,
+		"# HELP qframe_health_vital_age_seconds Seconds since the last sign of a vital.",
+		"# TYPE qframe_health_vital_age_seconds gauge",
+		`qframe_health_vital_age_seconds{vital="v1"} 90`,
+		"",
+	}
+	got := he.getMetrics(now.Add(90 * time.Second))
+	assert.Equal(t, strings.Join(exp, "\n"), got)
+}
+
+func TestHealthEndpoint_HandleMetrics(t *testing.T) {
+	he := NewHealthEndpoint([]string{"test"})
+	rec := httptest.NewRecorder()
+	he.HandleMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, 200, rec.Code)
+	assert.Equal(t, metricsContentType, rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "qframe_health_running_containers -1\n")
+}
+
+func TestMetricLine(t *testing.T) {
+	got := metricLine("vital_age_seconds", map[string]string{"vital": "a\"b"}, 1.5)
+	assert.Equal(t, `qframe_health_vital_age_seconds{vital="a\"b"} 1.5`, got)
+}
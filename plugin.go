@@ -1,10 +1,10 @@
 package qcache_health
 
 import (
-	"context"
 	"fmt"
-	"github.com/docker/docker/client"
+	"github.com/docker/docker/api/types"
 	"github.com/qframe/types/health"
+	"github.com/qframe/types/docker-events"
 	"github.com/urfave/negroni"
 	"net/http"
 	"time"
@@ -19,17 +19,22 @@ const (
 	version   = "0.1.3"
 	pluginTyp = qtypes_constants.CACHE
 	pluginPkg = "health"
-	dockerAPI = "v1.29"
-)
-
-var (
-	ctx = context.Background()
 )
 
 type Plugin struct {
 	*qtypes_plugin.Plugin
-	cli *client.Client
 	HealthEndpoint  *HealthEndpoint
+	httpSrv *httpServer
+	reconciledAt time.Time
+	// routineTypes restricts the routine types registered on first sight, nil allows all
+	routineTypes map[string]bool
+	ignoredTypes map[string]bool
+	selector ContainerSelector
+	envCache map[string]map[string]string
+	startCache map[string]time.Time
+	swarm swarmPoll
+	engines []*dockerEngine
+	reconcileReqs chan reconcileRequest
 }
 
 
@@ -45,10 +50,108 @@ func New(qChan qtypes_qchannel.QChan, cfg *config.Config, name string) (Plugin,
 	if ignoreStats {
 		he = NewHealthEndpoint([]string{"log","logSkip", "logWrongType"})
 	}
-	return Plugin{
+	groups := map[string][]string{}
+	if !ignoreStats {
+		groups["stats"] = []string{"stats"}
+		he.RegisterChecker(NewStatsChecker())
+	}
+	if !ignoreLogs {
+		groups["logs"] = []string{"log", "logSkip", "logWrongType"}
+		he.RegisterChecker(NewLogsChecker())
+	}
+	he.SetRoutineGroups(groups)
+	ignored := map[string]bool{}
+	if ignoreStats {
+		ignored["stats"] = true
+	}
+	if ignoreLogs {
+		ignored["log"], ignored["logSkip"], ignored["logWrongType"] = true, true, true
+	}
+	var allowed map[string]bool
+	if types := p.CfgStringOr("routine-types", ""); types != "" {
+		allowed = map[string]bool{}
+		for _, typ := range strings.Split(types, ",") {
+			typ = strings.TrimSpace(typ)
+			if typ == "" || ignored[typ] {
+				continue
+			}
+			allowed[typ] = true
+			he.RegisterRoutineType(typ)
+		}
+	}
+	rules, err := ParseRules(p.LocalCfg, fmt.Sprintf("%s.%s.rules.", p.Typ, p.Name))
+	if err != nil {
+		return Plugin{}, err
+	}
+	for _, r := range rules {
+		he.RegisterChecker(r)
+	}
+	policies, err := ParseVitalPolicies(p.LocalCfg, fmt.Sprintf("%s.%s.vitals.", p.Typ, p.Name))
+	if err != nil {
+		return Plugin{}, err
+	}
+	if len(policies) > 0 {
+		he.SetVitalPolicies(policies)
+		he.RegisterChecker(NewVitalsChecker())
+	}
+	he.SetUnhealthyCode(p.CfgIntOr("unhealthy-status-code", http.StatusOK))
+	he.SetDegradedCode(p.CfgIntOr("degraded-status-code", http.StatusOK))
+	he.SetHistoryCapacity(p.CfgIntOr("history-capacity", defaultHistoryCapacity))
+	he.SetTransitionPolicy(TransitionPolicy{
+		UnhealthyAfter: p.CfgIntOr("flap.unhealthy-after", 0),
+		UnhealthyWindow: p.CfgIntOr("flap.unhealthy-window", 0),
+		RecoverAfter: p.CfgIntOr("flap.recover-after", 0),
+		MinDwell: time.Duration(p.CfgIntOr("flap.min-dwell-ms", 0))*time.Millisecond,
+	})
+	he.SetLiveTimeout(time.Duration(p.CfgIntOr("live-timeout-ms", 10000))*time.Millisecond)
+	he.SetGracePeriod(time.Duration(p.CfgIntOr("grace-period-ms", 0))*time.Millisecond)
+	if threshold := p.CfgIntOr("container-health.threshold", 0); threshold > 0 {
+		severity := p.CfgStringOr("container-health.severity", Degraded)
+		if severity != Degraded && severity != Unhealthy {
+			return Plugin{}, fmt.Errorf("Could not use container-health.severity '%s', use degraded or unhealthy", severity)
+		}
+		he.RegisterChecker(NewContainerHealthChecker(threshold, severity))
+	}
+	if p.CfgBoolOr("swarm.enabled", false) {
+		severity := p.CfgStringOr("swarm.severity", Degraded)
+		if severity != Degraded && severity != Unhealthy {
+			return Plugin{}, fmt.Errorf("Could not use swarm.severity '%s', use degraded or unhealthy", severity)
+		}
+		he.EnableServices(time.Duration(p.CfgIntOr("swarm.converge-timeout-ms", int(defaultConvergeTimeout/time.Millisecond)))*time.Millisecond)
+		he.RegisterChecker(NewSwarmChecker(severity))
+	}
+	selector, err := NewContainerSelector(
+		p.CfgStringOr("select.include-labels", ""),
+		p.CfgStringOr("select.exclude-labels", ""),
+		p.CfgStringOr("select.include-env", ""),
+		p.CfgStringOr("select.exclude-env", ""),
+		p.CfgStringOr("select.include-names", ""),
+		p.CfgStringOr("select.exclude-names", ""),
+	)
+	if err != nil {
+		return Plugin{}, err
+	}
+	plug := Plugin{
 		Plugin: p,
 		HealthEndpoint:	he,
-	}, nil
+		httpSrv: &httpServer{},
+		routineTypes: allowed,
+		ignoredTypes: ignored,
+		selector: selector,
+		envCache: map[string]map[string]string{},
+		startCache: map[string]time.Time{},
+		reconcileReqs: make(chan reconcileRequest),
+	}
+	engines, err := plug.parseEngines()
+	if err != nil {
+		return Plugin{}, err
+	}
+	plug.engines = engines
+	he.RegisterChecker(NewDockerChecker())
+	for _, typ := range he.RoutineTypes() {
+		he.SetMaxSilence(typ, plug.maxSilence(typ))
+	}
+	return plug, nil
 }
 
 func (p *Plugin) RoutineAdd(routineType string, rt Routine) {
@@ -67,49 +170,121 @@ func (p *Plugin) RoutineDel(routineType string, rt Routine) {
 
 func (p *Plugin) SetHealth(status, msg string) {
 	err := p.HealthEndpoint.SetHealth(status, msg)
+	if isTransitionSuppressed(err) {
+		p.Log("debug", fmt.Sprintf("%s for msg '%s' suppressed: %s", status, msg, err.Error()))
+		return
+	}
 	if err != nil {
 		p.Log("error", fmt.Sprintf("%s for msg '%s': %s", status, msg, err.Error()))
 	}
 }
 
 func (p *Plugin) handleRoutines(hb qtypes_health.HealthBeat) {
-	rt := NewRoutine(hb.Actor, hb.Action, hb.Time)
-	switch hb.Type {
-	case "routine.log":
-		switch hb.Action {
-		case "start":
-			p.RoutineAdd("log", rt)
-		case "stop":
-			p.RoutineDel("log", rt)
-		}
-	case "routine.logSkip":
-		switch hb.Action {
-		case "start":
-			p.RoutineAdd("logSkip", rt)
-		case "stop":
-			p.RoutineDel("logSkip", rt)
-		}
-	case "routine.logWrongType":
-		switch hb.Action {
-		case "start":
-			p.RoutineAdd("logWrongType", rt)
-		case "stop":
-			p.RoutineDel("logWrongType", rt)
-		}
-	case "routine.stats":
-		switch hb.Action {
-		case "start":
-			p.RoutineAdd("stats", rt)
-		case "stop":
-			p.RoutineDel("stats", rt)
-		}
+	typ := strings.TrimPrefix(hb.Type, "routine.")
+	if !p.acceptRoutineType(typ) {
+		p.rejectBeat(hb, "routine type not accepted")
+		return
+	}
+	if !IsRoutineAction(hb.Action) {
+		p.rejectBeat(hb, "unknown action")
+		return
+	}
+	e := p.engineFor(hb.Tags)
+	if e == nil {
+		p.rejectBeat(hb, "unknown engine")
+		return
+	}
+	rt := NewRoutine(routineActor(e, hb.Actor), hb.Action, hb.Time)
+	err := p.HealthEndpoint.RoutineAction(typ, hb.Action, rt)
+	switch {
+	case isInvalidTransition(err):
+		p.Log("warn", err.Error())
+		p.HealthEndpoint.RejectBeat(hb.Type)
+	case err != nil:
+		p.rejectBeat(hb, err.Error())
 	}
 }
 
+// acceptRoutineType registers unknown routine types on first sight, unless they are ignored or
+// not part of the 'routine-types' allow-list.
+func (p *Plugin) acceptRoutineType(typ string) bool {
+	if typ == "" || p.ignoredTypes[typ] {
+		return false
+	}
+	if p.HealthEndpoint.HasRoutineType(typ) {
+		return true
+	}
+	if p.routineTypes != nil && !p.routineTypes[typ] {
+		return false
+	}
+	if p.HealthEndpoint.RegisterRoutineType(typ) {
+		p.Log("info", fmt.Sprintf("Registered routine type '%s'", typ))
+		p.HealthEndpoint.SetMaxSilence(typ, p.maxSilence(typ))
+	}
+	return true
+}
+
+// maxSilence reads 'routines.<type>.max-silence-ms', after which a routine without beat is stale.
+func (p *Plugin) maxSilence(typ string) time.Duration {
+	return time.Duration(p.CfgIntOr(fmt.Sprintf("routines.%s.max-silence-ms", typ), 0))*time.Millisecond
+}
+
+func (p *Plugin) rejectBeat(hb qtypes_health.HealthBeat, reason string) {
+	p.Log("debug", fmt.Sprintf("Dropped HealthBeat %s/%s/%s: %s", hb.Type, hb.Actor, hb.Action, reason))
+	p.HealthEndpoint.RejectBeat(hb.Type)
+}
+
 func (p *Plugin) handleVitals(hb qtypes_health.HealthBeat) {
 	p.HealthEndpoint.UpsertVitals(hb.Actor, hb.Action, hb.Time)
 }
 
+func (p *Plugin) expireVitals() {
+	for _, n := range p.HealthEndpoint.ExpireVitals(time.Now()) {
+		p.Log("info", fmt.Sprintf("Removed expired vital '%s'", n))
+	}
+}
+
+// handleContainerEvent keeps the container inventory of the engine up to date and tracks the HEALTHCHECK status
+// of containers, seeded by the inventory of the docker-events collector and updated by health_status events.
+// Events of several engines have to be tagged with the engine, otherwise the inventories rely on the resync.
+func (p *Plugin) handleContainerEvent(ce qtypes_docker_events.ContainerEvent) {
+	id := ce.Event.Actor.ID
+	name := ce.Event.Actor.Attributes["name"]
+	var health *types.Health
+	if cnt := ce.Container.ContainerJSONBase; cnt != nil {
+		id = cnt.ID
+		name = ce.GetContainerName()
+		if cnt.State != nil {
+			health = cnt.State.Health
+		}
+	}
+	if id == "" {
+		id = ce.Event.ID
+	}
+	if id == "" {
+		return
+	}
+	e := p.engineFor(ce.Tags)
+	if e == nil {
+		p.Log("debug", fmt.Sprintf("Dropped ContainerEvent %s/%s of unknown engine '%s'", id, ce.Event.Action, ce.Tags[engineTag]))
+		return
+	}
+	e.updateInventory(id, name, ce)
+	id = engineID(e.name, id)
+	switch ce.Event.Action {
+	case "health_status":
+		status := strings.TrimSpace(ce.Event.Actor.Attributes["status"])
+		p.HealthEndpoint.SetContainerHealth(id, name, status, ce.Time)
+	case "die", "destroy":
+		p.HealthEndpoint.DelContainerHealth(id)
+		delete(p.startCache, shortID(id))
+	case "start":
+		if health != nil && health.Status != "" {
+			p.HealthEndpoint.SetContainerHealth(id, name, health.Status, ce.Time)
+		}
+	}
+}
+
 func (p *Plugin) handleHB(hb qtypes_health.HealthBeat) {
 	p.Log("debug", fmt.Sprintf("Received HealthBeat: %v", hb))
 	switch {
@@ -117,6 +292,8 @@ func (p *Plugin) handleHB(hb qtypes_health.HealthBeat) {
 		p.handleRoutines(hb)
 	case hb.Type == "vitals":
 		p.handleVitals(hb)
+	default:
+		p.rejectBeat(hb, "unknown beat type")
 	}
 }
 
@@ -126,22 +303,49 @@ func (p *Plugin) Run() (err error) {
 	dc := p.QChan.Data.Join()
 	done := p.QChan.Done.Join()
 	tc := p.QChan.Tick.Join()
-	go p.startHTTP()
-	p.StartTicker("health-ticker", 2500)
-	err = p.connectingDocker()
+	err = p.startHTTP()
 	if err != nil {
+		p.Log("error", err.Error())
 		return
 	}
+	defer p.stopHTTP()
+	p.StartTicker("health-ticker", 2500)
+	for _, e := range p.engines {
+		if err = p.connectingDocker(e); err != nil {
+			p.dockerFailed(e, err, time.Now())
+			err = nil
+		}
+	}
+	p.HealthEndpoint.Tick(time.Now())
 	for {
 		select {
 		case <-tc.Read:
+			p.HealthEndpoint.Tick(time.Now())
+			p.expireVitals()
+			p.ensureEngines(time.Now())
+			// evaluated even without reachable engine (-1 containers), so that the other checks and
+			// the transition policy keep going
 			cntCount := p.getRunningCntCount()
+			if p.HealthEndpoint.ServicesEnabled() {
+				p.updateServices(time.Now())
+			}
 			p.checkHealth(cntCount)
+			if cntCount >= 0 {
+				p.reconcileOnTick(time.Now())
+			}
+		case rq := <-p.reconcileReqs:
+			p.handleReconcileRequest(rq)
 		case val := <-dc.Read:
 			switch val.(type) {
 			case qtypes_health.HealthBeat:
 				hb := val.(qtypes_health.HealthBeat)
 				p.handleHB(hb)
+			case qtypes_docker_events.ContainerEvent:
+				ce := val.(qtypes_docker_events.ContainerEvent)
+				p.handleContainerEvent(ce)
+			case qtypes_docker_events.ServiceEvent:
+				se := val.(qtypes_docker_events.ServiceEvent)
+				p.handleServiceEvent(se)
 			}
 		case err = <- p.ErrChan:
 			return
@@ -152,68 +356,148 @@ func (p *Plugin) Run() (err error) {
 	return
 }
 
-func (p *Plugin) connectingDocker() (err error) {
-	dockerHost := p.CfgStringOr("docker-host", "unix:///var/run/docker.sock")
-	p.cli, err = client.NewClient(dockerHost, dockerAPI, nil, nil)
-	if err != nil {
-		p.Log("error", fmt.Sprintf("Could not connect docker/docker/client to '%s': %v", dockerHost, err))
+// connectingDocker creates the client of the engine, using the API version negotiated with the engine
+// unless 'docker-api-version' is set.
+func (p *Plugin) connectingDocker(e *dockerEngine) (err error) {
+	if e.cli, err = p.newDockerClient(e); err != nil {
+		return
 	}
+	if err = p.negotiateDocker(e); err != nil {
+		return
+	}
+	p.dockerConnected(e)
 	return
 }
 
+// ensureEngines reconnects the unreachable engines and reports whether one of them is reachable.
+func (p *Plugin) ensureEngines(t time.Time) bool {
+	reachable := false
+	for _, e := range p.engines {
+		if p.ensureDocker(e, t) {
+			reachable = true
+		}
+	}
+	return reachable
+}
+
+// getRunningCntCount returns the number of selected running containers of the inventories of the
+// reachable engines, -1 if no engine is reachable. IDs of named engines are namespaced as '<engine>/<id>'.
 func (p *Plugin) getRunningCntCount() int {
-	info, err := p.cli.Info(ctx)
-	if err != nil {
-		msg := fmt.Sprintf("Error during Info(): %s", err)
-		p.Log("error", msg)
-		p.SetHealth("unhealthy", msg)
+	now := time.Now()
+	running := map[string]string{}
+	excluded := map[string]string{}
+	listed := map[string]bool{}
+	for _, e := range p.engines {
+		if !e.conn.reachable {
+			continue
+		}
+		due, err := p.resyncDue(e, now)
+		if err == nil && due {
+			err = p.syncInventory(e, now)
+		}
+		if err != nil {
+			p.dockerFailed(e, err, now)
+			continue
+		}
+		for _, cnt := range e.inventory.cnts {
+			id := engineID(e.name, cnt.ID)
+			listed[id] = true
+			name := ""
+			if len(cnt.Names) > 0 {
+				name = strings.TrimPrefix(cnt.Names[0], "/")
+			}
+			if !p.selectContainer(e, cnt, name) {
+				excluded[id] = name
+				continue
+			}
+			running[id] = name
+		}
+	}
+	if p.reachableEngines() == 0 {
 		return -1
 	}
-	return info.ContainersRunning
+	for id := range p.envCache {
+		if !listed[id] {
+			delete(p.envCache, id)
+		}
+	}
+	listedShort := map[string]bool{}
+	for id := range listed {
+		listedShort[shortID(id)] = true
+	}
+	for id := range p.startCache {
+		if !listedShort[id] {
+			delete(p.startCache, id)
+		}
+	}
+	p.HealthEndpoint.SetExcludedContainers(excluded)
+	p.HealthEndpoint.SetContainers(running)
+	if p.HealthEndpoint.GracePeriod() > 0 {
+		p.updateContainerStarts()
+	}
+	return len(running)
 }
 
-func (p *Plugin) checkHealth(cntCount int) {
-	ignoreStats := p.CfgBoolOr("ignore-stats", false)
-	ignoreLogs := p.CfgBoolOr("ignore-logs", false)
-	msg := []string{fmt.Sprintf("RunningContainers:%d", cntCount)}
-	if ! ignoreStats {
-		statsCnt := p.HealthEndpoint.CountRoutine("stats")
-		msg = append(msg, fmt.Sprintf("metricsGoRoutines:%d", statsCnt))
-		if cntCount == statsCnt {
-			p.SetHealth("healthy", strings.Join(msg, " | "))
-		} else {
-			p.SetHealth("unhealthy", strings.Join(msg, " | "))
-			return
+// updateContainerStarts inspects the containers not covered by routines to learn their start time,
+// as ContainerList() only provides the creation time. Start times are cached until the container
+// dies or leaves the inventory.
+func (p *Plugin) updateContainerStarts() {
+	starts := map[string]time.Time{}
+	for _, id := range p.HealthEndpoint.UncoveredContainers() {
+		if start, ok := p.startCache[id]; ok {
+			starts[id] = start
+			continue
 		}
-	}
-	if !ignoreLogs {
-		lCnt := p.HealthEndpoint.CountRoutine("log")
-		lSkipCnt := p.HealthEndpoint.CountRoutine("logSkip")
-		lWrongType := p.HealthEndpoint.CountRoutine("logWrongType")
-		msg = append(msg, fmt.Sprintf("logsGoRoutine:(%d [logs] + %d [skipped] + %d [non json-file])", lCnt, lSkipCnt, lWrongType))
-		if cntCount == (lCnt + lSkipCnt + lWrongType) {
-			p.SetHealth("healthy", strings.Join(msg, " | "))
-		} else {
-			p.SetHealth("unhealthy", strings.Join(msg, " | "))
-			return
+		engine, cntID := splitEngineID(id)
+		e := p.getEngine(engine)
+		if e == nil || !e.conn.reachable {
+			continue
+		}
+		c, cancel := p.dockerCtx()
+		cnt, err := e.cli.ContainerInspect(c, cntID)
+		cancel()
+		if err != nil {
+			p.Log("warn", fmt.Sprintf("Error during ContainerInspect(%s): %s", id, err))
+			continue
+		}
+		start, err := time.Parse(time.RFC3339Nano, cnt.State.StartedAt)
+		if err != nil {
+			p.Log("warn", fmt.Sprintf("Could not parse StartedAt '%s' of container %s: %s", cnt.State.StartedAt, id, err))
+			continue
 		}
+		starts[id] = start
+		p.startCache[id] = start
 	}
-	p.SetHealth("healthy", strings.Join(msg, " | "))
+	p.HealthEndpoint.SetContainerStarts(starts)
 }
 
-func (p *Plugin) startHTTP() {
-	bindHost := p.CfgStringOr("bind-host", "0.0.0.0")
-	bindPort := p.CfgStringOr("bind-port", "8123")
-	bindAddr := fmt.Sprintf("%s:%s", bindHost, bindPort)
+func (p *Plugin) checkHealth(cntCount int) {
+	p.HealthEndpoint.SetRunningContainers(cntCount)
+	status, msg := p.HealthEndpoint.Evaluate([]string{fmt.Sprintf("RunningContainers:%d", cntCount)})
+	if res, ok := p.HealthEndpoint.GetCheckResult("docker"); ok && cntCount < 0 {
+		// Routine counts against unknown containers say nothing, the docker checker tells why
+		msg = res.Message
+	}
+	p.SetHealth(status, msg)
+}
+
+func (p *Plugin) httpHandler() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/_health", p.HealthEndpoint.Handle)
+	mux.HandleFunc("/_health/live", p.HealthEndpoint.HandleLive)
+	mux.HandleFunc("/_health/ready", p.HealthEndpoint.HandleReady)
+	mux.HandleFunc("/_health/routines", p.HealthEndpoint.HandleRoutines)
+	mux.HandleFunc("/_health/routines/", p.HealthEndpoint.HandleRoutines)
+	mux.HandleFunc("/_health/history", p.HealthEndpoint.HandleHistory)
+	mux.HandleFunc("/_health/reconcile", p.HandleReconcile)
+	mux.HandleFunc("/_health/events", p.HealthEndpoint.HandleEvents)
+	mux.HandleFunc("/_health/ui", p.HealthEndpoint.HandleUI)
+	mux.HandleFunc("/metrics", p.HealthEndpoint.HandleMetrics)
 	n := negroni.New()
-	n.UseHandler(mux)
 	n.Use(negroni.HandlerFunc(p.LogMiddleware))
-	p.Log("info", fmt.Sprintf("Start health-endpoint: %s", bindAddr))
-	err :=  http.ListenAndServe(bindAddr, n)
-	p.ErrChan <- err
-	p.Log("error", err.Error())
+	n.Use(negroni.HandlerFunc(p.AuthMiddleware))
+	n.UseHandler(mux)
+	return n
 }
 
 func (p *Plugin) LogMiddleware(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
@@ -8,6 +8,14 @@ import (
 	"github.com/qframe/types/messages"
 	"fmt"
 	"github.com/qframe/types/qchannel"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/qframe/types/docker-events"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
 )
 
 func TestPlugin_checkHealth(t *testing.T) {
@@ -65,3 +73,207 @@ func TestPlugin_handleHB(t *testing.T) {
 	assert.Equal(t, 0, p.HealthEndpoint.CountRoutine("logSkip"))
 	assert.Equal(t, 0, p.HealthEndpoint.CountRoutine("stats"))
 }
+
+func TestPlugin_handleHBDynamicTypes(t *testing.T) {
+	p, _ := New(qtypes_qchannel.NewQChan(), &config.Config{}, "test")
+	b := qtypes_messages.NewBase("base")
+	p.handleHB(qtypes_health.NewHealthBeat(b, "routine.journald", "id1", "start"))
+	assert.Equal(t, 1, p.HealthEndpoint.CountRoutine("journald"), "Registered on first sight")
+	p.handleHB(qtypes_health.NewHealthBeat(b, "routine.journald", "id1", "bounce"))
+	p.handleHB(qtypes_health.NewHealthBeat(b, "routine.", "id1", "start"))
+	p.handleHB(qtypes_health.NewHealthBeat(b, "foo", "id1", "start"))
+	assert.Equal(t, map[string]int{"routine.journald": 1, "routine.": 1, "foo": 1}, p.HealthEndpoint.GetRejectedBeats())
+}
+
+func TestPlugin_handleHBBeat(t *testing.T) {
+	cfg := config.NewConfig([]config.Provider{config.NewStatic(map[string]string{
+		"cache.test.routines.journald.max-silence-ms": "500",
+	})})
+	p, _ := New(qtypes_qchannel.NewQChan(), cfg, "test")
+	b := qtypes_messages.NewBase("base")
+	p.handleHB(qtypes_health.NewHealthBeat(b, "routine.log", "id1", "beat"))
+	assert.Equal(t, map[string]int{"routine.log": 1}, p.HealthEndpoint.GetRejectedBeats(), "Beat without start")
+	p.handleHB(qtypes_health.NewHealthBeat(b, "routine.log", "id1", "stop"))
+	assert.Equal(t, map[string]int{"routine.log": 2}, p.HealthEndpoint.GetRejectedBeats(), "Stop without start")
+	p.handleHB(qtypes_health.NewHealthBeat(b, "routine.journald", "id1", "start"))
+	time.Sleep(600 * time.Millisecond)
+	assert.Equal(t, map[string][]string{"journald": {"id1"}}, p.HealthEndpoint.GetStaleRoutines(), "Max silence of dynamic types is configured on registration")
+	p.handleHB(qtypes_health.NewHealthBeat(qtypes_messages.NewBase("base"), "routine.journald", "id1", "beat"))
+	assert.Equal(t, 1, p.HealthEndpoint.CountRoutine("journald"))
+}
+
+func TestPlugin_handleContainerEvent(t *testing.T) {
+	cfg := config.NewConfig([]config.Provider{config.NewStatic(map[string]string{
+		"cache.test.container-health.threshold": "1",
+		"cache.test.container-health.severity": "unhealthy",
+	})})
+	p, err := New(qtypes_qchannel.NewQChan(), cfg, "test")
+	assert.NoError(t, err)
+	assert.Contains(t, p.HealthEndpoint.Checkers(), "container_health")
+	b := qtypes_messages.NewBase("base")
+	// already running container, sent by the collector on start-up
+	cnt := types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{
+		ID: "aaaaaaaaaaaa0000",
+		Name: "/web",
+		State: &types.ContainerState{Health: &types.Health{Status: "starting"}},
+	}}
+	de := qtypes_docker_events.NewDockerEvent(b, events.Message{ID: "aaaaaaaaaaaa0000", Type: "container", Action: "start"})
+	p.handleContainerEvent(qtypes_docker_events.NewContainerEvent(de, cnt))
+	assert.Equal(t, dockerHealthStarting, p.HealthEndpoint.GetContainerHealth()["aaaaaaaaaaaa"].Status)
+	msg := events.Message{Type: "container", Action: "health_status", Actor: events.Actor{
+		ID: "aaaaaaaaaaaa0000",
+		Attributes: map[string]string{"name": "web", "status": " unhealthy"},
+	}}
+	p.handleContainerEvent(qtypes_docker_events.NewContainerEvent(qtypes_docker_events.NewDockerEvent(b, msg), types.ContainerJSON{}))
+	assert.Equal(t, ContainerHealth{Name: "web", Status: dockerHealthUnhealthy, Time: b.Time}, p.HealthEndpoint.GetContainerHealth()["aaaaaaaaaaaa"])
+	s, _ := p.HealthEndpoint.Evaluate([]string{})
+	assert.Equal(t, Unhealthy, s)
+	cr := p.HealthEndpoint.GetJSON()["checks"].(map[string]interface{})["container_health"].(map[string]interface{})
+	assert.Equal(t, Unhealthy, cr["status"])
+	assert.Equal(t, "1 containers unhealthy:[web(aaaaaaaaaaaa)]", cr["message"])
+	msg.Action = "die"
+	p.handleContainerEvent(qtypes_docker_events.NewContainerEvent(qtypes_docker_events.NewDockerEvent(b, msg), types.ContainerJSON{}))
+	assert.Len(t, p.HealthEndpoint.GetContainerHealth(), 0)
+}
+
+func TestNew_ContainerHealthSeverity(t *testing.T) {
+	cfg := config.NewConfig([]config.Provider{config.NewStatic(map[string]string{
+		"cache.test.container-health.threshold": "1",
+		"cache.test.container-health.severity": "info",
+	})})
+	_, err := New(qtypes_qchannel.NewQChan(), cfg, "test")
+	assert.Error(t, err)
+}
+
+func TestPlugin_handleHBAllowedTypes(t *testing.T) {
+	cfg := config.NewConfig([]config.Provider{config.NewStatic(map[string]string{
+		"cache.test.routine-types": "journald, syslog",
+		"cache.test.ignore-stats": "true",
+	})})
+	p, err := New(qtypes_qchannel.NewQChan(), cfg, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"journald", "log", "logSkip", "logWrongType", "syslog"}, p.HealthEndpoint.RoutineTypes())
+	b := qtypes_messages.NewBase("base")
+	for _, typ := range []string{"journald", "log", "other", "stats"} {
+		p.handleHB(qtypes_health.NewHealthBeat(b, fmt.Sprintf("routine.%s", typ), "id1", "start"))
+	}
+	assert.Equal(t, 1, p.HealthEndpoint.CountRoutine("journald"))
+	assert.Equal(t, 1, p.HealthEndpoint.CountRoutine("log"), "Built-in types are always accepted")
+	assert.Equal(t, -1, p.HealthEndpoint.CountRoutine("other"))
+	assert.Equal(t, -1, p.HealthEndpoint.CountRoutine("stats"), "Ignored types are rejected")
+	assert.Equal(t, map[string]int{"routine.other": 1, "routine.stats": 1}, p.HealthEndpoint.GetRejectedBeats())
+}
+
+// dockerAPI is the API version the fake engines announce
+const dockerAPI = "v1.29"
+
+// fakePing answers the version negotiation of connectingDocker().
+func fakePing(w http.ResponseWriter, r *http.Request) bool {
+	if r.URL.Path != "/_ping" {
+		return false
+	}
+	w.Header().Set("API-Version", strings.TrimPrefix(dockerAPI, "v"))
+	w.Write([]byte("OK"))
+	return true
+}
+
+// fakeDocker serves the engine API calls used by the plugin for the given containers.
+func fakeDocker(t *testing.T, cnts []types.Container) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fakePing(w, r) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case fmt.Sprintf("/%s/containers/json", dockerAPI):
+			json.NewEncoder(w).Encode(cnts)
+		case fmt.Sprintf("/%s/info", dockerAPI):
+			json.NewEncoder(w).Encode(types.Info{ContainersRunning: len(cnts)})
+		default:
+			for _, c := range cnts {
+				if strings.HasPrefix(r.URL.Path, fmt.Sprintf("/%s/containers/%s", dockerAPI, c.ID[:shortIDLen])) {
+					started := time.Unix(c.Created, 0).Format(time.RFC3339Nano)
+					json.NewEncoder(w).Encode(types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{
+						ID: c.ID,
+						State: &types.ContainerState{Running: true, StartedAt: started},
+					}})
+					return
+				}
+			}
+			t.Logf("fakeDocker: unexpected request %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func newDockerPlugin(t *testing.T, srv *httptest.Server, cfgMap map[string]string) Plugin {
+	cfgMap["cache.test.docker-host"] = strings.Replace(srv.URL, "http://", "tcp://", 1)
+	p := newCfgPlugin(t, cfgMap)
+	assert.NoError(t, p.connectingDocker(p.engines[0]))
+	return p
+}
+
+func TestPlugin_checkHealthDiscrepancies(t *testing.T) {
+	srv := fakeDocker(t, []types.Container{{ID: "aaaaaaaaaaaa0000", Names: []string{"/web"}}})
+	defer srv.Close()
+	p := newDockerPlugin(t, srv, map[string]string{"log.level": "error"})
+	p.SetHealth(Healthy, "Start")
+	cntCount := p.getRunningCntCount()
+	assert.Equal(t, 1, cntCount)
+	p.checkHealth(cntCount)
+	s, m := p.HealthEndpoint.CurrentHealth()
+	assert.Equal(t, Unhealthy, s)
+	assert.Equal(t, "RunningContainers:1 | metricsGoRoutines:0 | stats without routine:[web(aaaaaaaaaaaa)]", m)
+}
+
+func TestPlugin_checkHealthGracePeriod(t *testing.T) {
+	srv := fakeDocker(t, []types.Container{
+		{ID: "aaaaaaaaaaaa0000", Names: []string{"/fresh"}, Created: time.Now().Unix()},
+		{ID: "bbbbbbbbbbbb0000", Names: []string{"/old"}, Created: time.Now().Add(-time.Hour).Unix()},
+	})
+	defer srv.Close()
+	p := newDockerPlugin(t, srv, map[string]string{"log.level": "error", "cache.test.grace-period-ms": "60000"})
+	p.SetHealth(Healthy, "Start")
+	p.HealthEndpoint.AddRoutine("stats", NewRoutine("bbbbbbbbbbbb", "start", time.Now()))
+	p.HealthEndpoint.AddRoutine("log", NewRoutine("bbbbbbbbbbbb", "start", time.Now()))
+	cntCount := p.getRunningCntCount()
+	p.checkHealth(cntCount)
+	s, m := p.HealthEndpoint.CurrentHealth()
+	assert.Equal(t, Healthy, s, m)
+	assert.Equal(t, []string{"fresh(aaaaaaaaaaaa)"}, p.HealthEndpoint.GetPending())
+}
+
+func TestPlugin_updateContainerStartsCached(t *testing.T) {
+	fake := fakeDocker(t, []types.Container{{ID: "aaaaaaaaaaaa0000", Names: []string{"/fresh"}, Created: time.Now().Unix()}})
+	defer fake.Close()
+	inspects := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, fmt.Sprintf("/%s/containers/aaaaaaaaaaaa", dockerAPI)) {
+			inspects++
+		}
+		fake.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	p := newDockerPlugin(t, srv, map[string]string{"log.level": "error", "cache.test.grace-period-ms": "60000"})
+	for i := 0; i < 3; i++ {
+		p.getRunningCntCount()
+	}
+	assert.Equal(t, 1, inspects, "The start time is cached")
+	assert.Equal(t, []string{"fresh(aaaaaaaaaaaa)"}, p.HealthEndpoint.GetPending())
+	p.handleContainerEvent(containerEvent("die", "aaaaaaaaaaaa0000", "fresh", nil))
+	assert.Empty(t, p.startCache)
+}
+
+func TestNew_Rules(t *testing.T) {
+	cfg := config.NewConfig([]config.Provider{config.NewStatic(map[string]string{
+		"cache.test.rules.stats": "count(stats) >= 1",
+	})})
+	p, err := New(qtypes_qchannel.NewQChan(), cfg, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"stats", "logs", "rules.stats", "docker"}, p.HealthEndpoint.Checkers())
+	cfg = config.NewConfig([]config.Provider{config.NewStatic(map[string]string{
+		"cache.test.rules.stats": "count(stats) >=",
+	})})
+	_, err = New(qtypes_qchannel.NewQChan(), cfg, "test")
+	assert.Error(t, err, "Expressions are validated within New")
+}
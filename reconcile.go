@@ -0,0 +1,231 @@
+package qcache_health
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+	"github.com/docker/docker/api/types"
+)
+
+const (
+	shortIDLen = 12
+)
+
+// ReconcileReport is the outcome of comparing the routines against the running containers.
+type ReconcileReport struct {
+	Time 		time.Time
+	Fixed 		bool
+	Stale 		map[string][]string
+	Unrouted 	[]string
+	// Skipped lists the unreachable engines, whose routines are neither reported nor removed
+	Skipped 	[]string
+}
+
+func (rr ReconcileReport) GetJSON() map[string]interface{} {
+	return map[string]interface{}{
+		"time": rr.Time.Format(time.RFC3339Nano),
+		"fixed": rr.Fixed,
+		"stale_routines": rr.Stale,
+		"containers_without_routine": rr.Unrouted,
+		"skipped_engines": rr.Skipped,
+	}
+}
+
+// shortID truncates a container ID the same way the collectors do for their HealthBeats, keeping
+// the engine namespace of an '<engine>/<id>' ID.
+func shortID(id string) string {
+	engine, id := splitEngineID(id)
+	if len(id) > shortIDLen {
+		id = id[:shortIDLen]
+	}
+	return engineID(engine, id)
+}
+
+// Reconcile compares the routine sets of the routine groups against the running container IDs.
+// Routines without a container are reported as stale and removed if fix is set; containers without
+// any routine are reported. Routine types outside the groups (e.g. journald) are not container-backed
+// and left alone.
+func (he *HealthEndpoint) Reconcile(running []string, fix bool, t time.Time) ReconcileReport {
+	return he.ReconcileEngines(running, []string{}, fix, t)
+}
+
+// ReconcileEngines reconciles like Reconcile(), leaving out the routines of the skipped engines.
+func (he *HealthEndpoint) ReconcileEngines(running, skipped []string, fix bool, t time.Time) ReconcileReport {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	rr := ReconcileReport{
+		Time: t,
+		Fixed: fix,
+		Stale: map[string][]string{},
+		Unrouted: []string{},
+		Skipped: skipped,
+	}
+	isRunning := map[string]bool{}
+	for _, id := range running {
+		isRunning[shortID(id)] = true
+	}
+	isSkipped := map[string]bool{}
+	for _, e := range skipped {
+		isSkipped[e] = true
+	}
+	routed := map[string]bool{}
+	for typ := range he.groupedTypes() {
+		r, ok := he.goRoutines[typ]
+		if !ok {
+			continue
+		}
+		for _, id := range r.Get() {
+			if engine, _ := splitEngineID(id); isSkipped[engine] {
+				continue
+			}
+			if isRunning[id] {
+				routed[id] = true
+				continue
+			}
+			rr.Stale[typ] = append(rr.Stale[typ], id)
+			if fix {
+				rt, _ := r.GetRoutine(id)
+				r.Del(rt)
+				rt.transition(RoutineStopped, "reconcile", t)
+				he.keepStopped(typ, rt)
+				he.publishRoutine(typ, "del", rt, t)
+			}
+		}
+	}
+	for id := range isRunning {
+		if _, ok := he.excluded[id]; !ok && !routed[id] {
+			rr.Unrouted = append(rr.Unrouted, id)
+		}
+	}
+	sort.Strings(rr.Unrouted)
+	he.lastReconcile = &rr
+	return rr
+}
+
+// groupedTypes returns the routine types of all routine groups, which are expected to cover the containers.
+func (he *HealthEndpoint) groupedTypes() map[string]bool {
+	res := map[string]bool{}
+	for _, typs := range he.groups {
+		for _, typ := range typs {
+			res[typ] = true
+		}
+	}
+	return res
+}
+
+// reconcileRequest asks the Run() loop for an on-demand reconciliation, as the engines must
+// only be used from there.
+type reconcileRequest struct {
+	fix 	bool
+	res 	chan reconcileResult
+}
+
+type reconcileResult struct {
+	rr 		ReconcileReport
+	err 	error
+}
+
+func (p *Plugin) handleReconcileRequest(rq reconcileRequest) {
+	rr, err := p.RecoverUnhealthy(rq.fix)
+	rq.res <- reconcileResult{rr: rr, err: err}
+}
+
+// RecoverUnhealthy checks the list of running containers of all engines and tries
+// to automitigate if containers were not removed correctly. Unreachable engines are skipped,
+// it fails only if no engine is reachable. It must only be called from the Run() loop.
+func (p *Plugin) RecoverUnhealthy(fix bool) (rr ReconcileReport, err error) {
+	now := time.Now()
+	running := []string{}
+	skipped := []string{}
+	for _, e := range p.engines {
+		if e.cli == nil || !e.conn.reachable {
+			skipped = append(skipped, e.name)
+			continue
+		}
+		c, cancel := p.dockerCtx()
+		cnts, err := e.cli.ContainerList(c, types.ContainerListOptions{})
+		cancel()
+		if err != nil {
+			p.dockerFailed(e, fmt.Errorf("ContainerList(): %s", err), now)
+			skipped = append(skipped, e.name)
+			continue
+		}
+		for _, cnt := range cnts {
+			running = append(running, engineID(e.name, cnt.ID))
+		}
+	}
+	if len(skipped) == len(p.engines) {
+		return rr, fmt.Errorf("Could not reconcile, no docker engine reachable")
+	}
+	if len(skipped) > 0 {
+		p.Log("warn", fmt.Sprintf("Skipped unreachable engines during reconciliation: %v", skipped))
+	}
+	rr = p.HealthEndpoint.ReconcileEngines(running, skipped, fix, now)
+	for typ, ids := range rr.Stale {
+		action := "Found"
+		if fix {
+			action = "Removed"
+		}
+		p.Log("warn", fmt.Sprintf("%s %d %s routines without container: %v", action, len(ids), typ, ids))
+	}
+	if len(rr.Unrouted) > 0 {
+		p.Log("warn", fmt.Sprintf("Found %d containers without routine: %v", len(rr.Unrouted), rr.Unrouted))
+	}
+	return
+}
+
+// reconcileOnTick runs RecoverUnhealthy every 'reconcile-interval-ms' (disabled with 0),
+// removing stale routines if 'reconcile-mode' is 'fix'.
+func (p *Plugin) reconcileOnTick(t time.Time) {
+	interval := time.Duration(p.CfgIntOr("reconcile-interval-ms", 0))*time.Millisecond
+	if interval <= 0 || t.Sub(p.reconciledAt) < interval {
+		return
+	}
+	p.reconciledAt = t
+	_, err := p.RecoverUnhealthy(p.CfgStringOr("reconcile-mode", "report") == "fix")
+	if err != nil {
+		p.Log("error", err.Error())
+	}
+}
+
+// HandleReconcile runs the reconciliation on demand (POST) or returns the last report (GET).
+// On POST the query parameter 'fix' overrides 'reconcile-mode'.
+func (p *Plugin) HandleReconcile(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		rr := p.HealthEndpoint.LastReconcile()
+		if rr == nil {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": "No reconciliation run yet"})
+			return
+		}
+		writeJSON(w, http.StatusOK, rr.GetJSON())
+	case http.MethodPost:
+		fix := p.CfgStringOr("reconcile-mode", "report") == "fix"
+		if f := req.URL.Query().Get("fix"); f != "" {
+			fix = f == "true"
+		}
+		rq := reconcileRequest{fix: fix, res: make(chan reconcileResult, 1)}
+		select {
+		case p.reconcileReqs <- rq:
+		case <-req.Context().Done():
+			return
+		}
+		res := <-rq.res
+		rr, err := res.rr, res.err
+		if err != nil {
+			writeJSON(w, http.StatusBadGateway, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, rr.GetJSON())
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"error": "method not allowed"})
+	}
+}
+
+// LastReconcile returns the report of the last reconciliation, nil if none ran yet.
+func (he *HealthEndpoint) LastReconcile() *ReconcileReport {
+	he.mu.RLock()
+	defer he.mu.RUnlock()
+	return he.lastReconcile
+}
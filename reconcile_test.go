@@ -0,0 +1,86 @@
+package qcache_health
+
+import (
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+	"github.com/docker/docker/api/types"
+	"net/http"
+	"net/http/httptest"
+)
+
+func TestHealthEndpoint_Reconcile(t *testing.T) {
+	he := NewHealthEndpoint([]string{"log", "stats"})
+	he.SetRoutineGroups(map[string][]string{"logs": {"log"}, "stats": {"stats"}})
+	he.AddRoutine("log", NewRoutine("aaaaaaaaaaaa", "start", ts))
+	he.AddRoutine("log", NewRoutine("bbbbbbbbbbbb", "start", ts))
+	he.AddRoutine("stats", NewRoutine("bbbbbbbbbbbb", "start", ts))
+	running := []string{"aaaaaaaaaaaa0000", "cccccccccccc0000"}
+	rr := he.Reconcile(running, false, ts)
+	assert.Equal(t, map[string][]string{"log": {"bbbbbbbbbbbb"}, "stats": {"bbbbbbbbbbbb"}}, rr.Stale)
+	assert.Equal(t, []string{"cccccccccccc"}, rr.Unrouted)
+	assert.Equal(t, 2, he.CountRoutine("log"), "Report only")
+	rr = he.Reconcile(running, true, ts)
+	assert.True(t, rr.Fixed)
+	assert.Equal(t, 1, he.CountRoutine("log"))
+	assert.Equal(t, 0, he.CountRoutine("stats"))
+	assert.Equal(t, &rr, he.LastReconcile())
+	assert.Contains(t, he.GetJSON(), "reconcile")
+}
+
+func TestPlugin_RecoverUnhealthy(t *testing.T) {
+	srv := fakeDocker(t, []types.Container{{ID: "aaaaaaaaaaaa0000"}})
+	defer srv.Close()
+	p := newDockerPlugin(t, srv, map[string]string{"log.level": "error"})
+	p.RoutineAdd("log", NewRoutine("aaaaaaaaaaaa", "start", ts))
+	p.RoutineAdd("log", NewRoutine("bbbbbbbbbbbb", "start", ts))
+	rec := httptest.NewRecorder()
+	p.HandleReconcile(rec, httptest.NewRequest("GET", "/_health/reconcile", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code, "Nothing ran yet")
+	rec = httptest.NewRecorder()
+	go func() { p.handleReconcileRequest(<-p.reconcileReqs) }()
+	p.HandleReconcile(rec, httptest.NewRequest("POST", "/_health/reconcile?fix=true", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 1, p.HealthEndpoint.CountRoutine("log"))
+	rr := p.HealthEndpoint.LastReconcile()
+	assert.Equal(t, []string{"bbbbbbbbbbbb"}, rr.Stale["log"])
+	assert.Equal(t, []string{}, rr.Unrouted)
+}
+
+func TestPlugin_RecoverUnhealthyCustomType(t *testing.T) {
+	srv := fakeDocker(t, []types.Container{{ID: "aaaaaaaaaaaa0000"}})
+	defer srv.Close()
+	p := newDockerPlugin(t, srv, map[string]string{"log.level": "error"})
+	assert.True(t, p.acceptRoutineType("journald"))
+	p.RoutineAdd("journald", NewRoutine("host1", "start", ts))
+	rr, err := p.RecoverUnhealthy(true)
+	assert.NoError(t, err)
+	assert.NotContains(t, rr.Stale, "journald")
+	assert.Equal(t, 1, p.HealthEndpoint.CountRoutine("journald"), "Not container-backed, survives the fix")
+}
+
+func TestPlugin_RecoverUnhealthyEngines(t *testing.T) {
+	srvA := fakeDocker(t, []types.Container{{ID: "aaaaaaaaaaaa0000"}})
+	defer srvA.Close()
+	srvB := fakeDocker(t, []types.Container{{ID: "bbbbbbbbbbbb0000"}})
+	defer srvB.Close()
+	p := newCfgPlugin(t, map[string]string{
+		"log.level": "error",
+		"cache.test.engines": "a,b",
+		"cache.test.engines.a.docker-host": engineHost(srvA),
+		"cache.test.engines.b.docker-host": engineHost(srvB),
+	})
+	assert.True(t, p.ensureEngines(time.Now()))
+	p.RoutineAdd("log", NewRoutine("a/cccccccccccc", "start", ts))
+	p.RoutineAdd("log", NewRoutine("b/bbbbbbbbbbbb", "start", ts))
+	srvB.Close()
+	rr, err := p.RecoverUnhealthy(true)
+	assert.NoError(t, err, "Engine a is still reconciled")
+	assert.Equal(t, []string{"b"}, rr.Skipped)
+	assert.Equal(t, []string{"a/cccccccccccc"}, rr.Stale["log"])
+	assert.Equal(t, []string{"a/aaaaaaaaaaaa"}, rr.Unrouted)
+	assert.Equal(t, 1, p.HealthEndpoint.CountRoutine("log"), "Routines of the skipped engine are kept")
+	srvA.Close()
+	_, err = p.RecoverUnhealthy(true)
+	assert.Error(t, err)
+}
@@ -10,10 +10,12 @@ type Routine struct {
 	created time.Time
 	updated time.Time
 	lastBeat time.Time
+	state string
+	history []RoutineTransition
 }
 
 func NewRoutine(id, status string, t time.Time) Routine {
-	return Routine{id, status, t, t, t}
+	return Routine{id: id, status: status, created: t, updated: t, lastBeat: t}
 }
 
 func (r *Routine) GetID() string {
@@ -29,11 +31,42 @@ func (r *Routine) Update(rt Routine) (err error){
 		return fmt.Errorf("id missmatch (this.id=%s != other.id=%s", r.id, rt.id)
 	}
 	r.updated = rt.updated
+	r.lastBeat = rt.lastBeat
 	return
 }
 
+// GetState returns the lifecycle state, empty as long as the routine was not added.
+func (r *Routine) GetState() string {
+	return r.state
+}
+
+// GetHistory returns the latest transitions, oldest first.
+func (r *Routine) GetHistory() []RoutineTransition {
+	return append([]RoutineTransition{}, r.history...)
+}
+
+// transition changes the state and keeps the last routineHistoryCapacity transitions.
+func (r *Routine) transition(to, action string, t time.Time) {
+	r.history = append(r.history, RoutineTransition{From: r.state, To: to, Action: action, Time: t})
+	if len(r.history) > routineHistoryCapacity {
+		r.history = append([]RoutineTransition{}, r.history[len(r.history)-routineHistoryCapacity:]...)
+	}
+	r.state = to
+	r.status = action
+	r.updated = t
+	r.lastBeat = t
+}
+
+// GetUptime returns how long the routine is alive, up to its stop once it is stopped.
 func (r *Routine) GetUptime() time.Duration {
-	return r.updated.Sub(r.created)
+	return r.uptime(time.Now())
+}
+
+func (r *Routine) uptime(t time.Time) time.Duration {
+	if r.state == RoutineStopped {
+		return r.updated.Sub(r.created)
+	}
+	return t.Sub(r.created)
 }
 
 func (r *Routine) GetLastUpdateTime() time.Time {
@@ -44,4 +77,35 @@ func (r *Routine) GetDurSinceUpdate() time.Duration {
 	return time.Now().Sub(r.updated)
 }
 
+func (r *Routine) GetLastBeatTime() time.Time {
+	return r.lastBeat
+}
 
+// IsStale reports whether the routine was silent for longer than maxSilence at t, never if maxSilence is not positive.
+func (r *Routine) IsStale(t time.Time, maxSilence time.Duration) bool {
+	return maxSilence > 0 && t.Sub(r.lastBeat) > maxSilence
+}
+
+// GetJSON returns the routine with its timestamps and durations.
+func (r *Routine) GetJSON() map[string]interface{} {
+	return r.getJSON(time.Now())
+}
+
+func (r *Routine) getJSON(t time.Time) map[string]interface{} {
+	history := []map[string]interface{}{}
+	for _, x := range r.history {
+		history = append(history, x.GetJSON())
+	}
+	return map[string]interface{}{
+		"id": r.id,
+		"status": r.status,
+		"state": r.state,
+		"history": history,
+		"time_created": r.created.Format(time.RFC3339Nano),
+		"time_updated": r.updated.Format(time.RFC3339Nano),
+		"time_last_beat": r.lastBeat.Format(time.RFC3339Nano),
+		"uptime": r.uptime(t).String(),
+		"since_update": t.Sub(r.updated).String(),
+		"age": t.Sub(r.created).String(),
+	}
+}
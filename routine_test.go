@@ -29,6 +29,26 @@ func TestRoutine_GetUptime(t *testing.T) {
 	assert.NoError(t, err)
 	err = rt1.Update(rt2)
 	assert.Error(t, err)
-	got := rt1.GetUptime()
-	assert.Equal(t, tsUpdate.Sub(tsCreated), got)
+	assert.Equal(t, time.Hour, rt1.uptime(tsCreated.Add(time.Hour)), "Alive up to now")
+	rt = NewRoutine("id1", "start", tsCreated)
+	rt.transition(RoutineRunning, "start", tsCreated)
+	rt.transition(RoutineStopped, "stop", tsUpdate)
+	assert.Equal(t, tsUpdate.Sub(tsCreated), rt.uptime(tsCreated.Add(time.Hour)), "Alive up to the stop")
+}
+
+func TestRoutine_GetJSON(t *testing.T) {
+	rt := NewRoutine("id1", "start", ts)
+	exp := map[string]interface{}{
+		"id": "id1",
+		"status": "start",
+		"state": "",
+		"history": []map[string]interface{}{},
+		"time_created": ts.Format(time.RFC3339Nano),
+		"time_updated": ts.Format(time.RFC3339Nano),
+		"time_last_beat": ts.Format(time.RFC3339Nano),
+		"uptime": "1h0m0s",
+		"since_update": "1h0m0s",
+		"age": "1h0m0s",
+	}
+	assert.Equal(t, exp, rt.getJSON(ts.Add(time.Hour)))
 }
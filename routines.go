@@ -6,6 +6,7 @@ import (
 	"strings"
 	"fmt"
 	"sort"
+	"time"
 )
 
 
@@ -55,3 +56,40 @@ func (r *Routines) Del(rt Routine) {
 	r.keys.Remove(rt.GetID())
 	delete(r.values, rt.GetID())
 }
+
+// Beat updates the known routine with the same id.
+func (r *Routines) Beat(rt Routine) (err error) {
+	cur, ok := r.values[rt.GetID()]
+	if !ok {
+		return fmt.Errorf("key '%s' not existing", rt.GetID())
+	}
+	err = cur.Update(rt)
+	if err != nil {
+		return
+	}
+	r.set(cur)
+	return
+}
+
+// set replaces the routine with the same id.
+func (r *Routines) set(rt Routine) {
+	r.values[rt.GetID()] = rt
+}
+
+// GetRoutine returns the routine with the given id.
+func (r *Routines) GetRoutine(id string) (rt Routine, ok bool) {
+	rt, ok = r.values[id]
+	return
+}
+
+// getJSON lists the routines, marking the ones silent for longer than maxSilence as stale.
+func (r *Routines) getJSON(t time.Time, maxSilence time.Duration) []map[string]interface{} {
+	res := []map[string]interface{}{}
+	for _, k := range r.Get() {
+		rt := r.values[k]
+		rj := rt.getJSON(t)
+		rj["stale"] = rt.IsStale(t, maxSilence)
+		res = append(res, rj)
+	}
+	return res
+}
@@ -0,0 +1,107 @@
+package qcache_health
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	severityInfo = "info"
+)
+
+// Rule is a health rule defined in the configuration:
+//
+//	cache.health.rules.<name> = <expression>
+//	cache.health.rules.<name>.severity = unhealthy|degraded|info
+//
+// A rule fails if the expression is false or cannot be evaluated; the severity
+// is the status it reports then. Failing 'info' rules are only shown in the checks.
+type Rule struct {
+	name 		string
+	severity 	string
+	expr 		*Expr
+}
+
+func NewRule(name, expr, severity string) (r Rule, err error) {
+	if severity == "" {
+		severity = Unhealthy
+	}
+	switch severity {
+	case Unhealthy, Degraded, severityInfo:
+	default:
+		return r, fmt.Errorf("rule '%s': unknown severity '%s'", name, severity)
+	}
+	e, err := ParseExpr(expr)
+	if err != nil {
+		return r, fmt.Errorf("rule '%s': %v", name, err)
+	}
+	return Rule{name: name, severity: severity, expr: e}, nil
+}
+
+// ParseRules collects the rules from the flat configuration map below prefix.
+func ParseRules(cfg map[string]string, prefix string) (rules []Rule, err error) {
+	exprs := map[string]string{}
+	severities := map[string]string{}
+	for k, v := range cfg {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		key := strings.TrimPrefix(k, prefix)
+		switch {
+		case strings.HasSuffix(key, ".severity"):
+			severities[strings.TrimSuffix(key, ".severity")] = v
+		case strings.Contains(key, "."):
+			return nil, fmt.Errorf("unknown rule setting '%s'", k)
+		default:
+			exprs[key] = v
+		}
+	}
+	names := []string{}
+	for n := range exprs {
+		names = append(names, n)
+	}
+	for n := range severities {
+		if _, ok := exprs[n]; !ok {
+			return nil, fmt.Errorf("severity for rule '%s' without expression", n)
+		}
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		r, err := NewRule(n, exprs[n], severities[n])
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return
+}
+
+func (r Rule) Name() string {
+	return fmt.Sprintf("rules.%s", r.name)
+}
+
+func (r Rule) Check(he *HealthEndpoint) CheckResult {
+	res := CheckResult{
+		Status: Healthy,
+		Details: map[string]interface{}{
+			"expr": r.expr.String(),
+			"severity": r.severity,
+		},
+	}
+	ok, err := r.expr.Eval(he, time.Now())
+	res.Details["result"] = ok
+	if err != nil {
+		res.Details["error"] = err.Error()
+	}
+	if ok || r.severity == severityInfo {
+		return res
+	}
+	res.Status = r.severity
+	res.Message = fmt.Sprintf("%s failed:(%s)", r.Name(), r.expr)
+	if err != nil {
+		res.Message = fmt.Sprintf("%s failed:(%s): %v", r.Name(), r.expr, err)
+	}
+	return res
+}
@@ -0,0 +1,49 @@
+package qcache_health
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRules(t *testing.T) {
+	cfg := map[string]string{
+		"cache.health.rules.logs": "count(log)+count(logSkip)+count(logWrongType) == containers.running",
+		"cache.health.rules.stats": "count(stats) >= 1",
+		"cache.health.rules.stats.severity": "info",
+		"cache.health.bind-port": "8123",
+	}
+	rules, err := ParseRules(cfg, "cache.health.rules.")
+	assert.NoError(t, err)
+	assert.Len(t, rules, 2)
+	assert.Equal(t, "rules.logs", rules[0].Name())
+	assert.Equal(t, Unhealthy, rules[0].severity)
+	assert.Equal(t, severityInfo, rules[1].severity)
+	for k, v := range map[string]string{
+		"cache.health.rules.x": "count(log)",
+		"cache.health.rules.x.severity": "panic",
+		"cache.health.rules.x.foo": "bar",
+	} {
+		_, err = ParseRules(map[string]string{"cache.health.rules.x": "true", k: v}, "cache.health.rules.")
+		assert.Error(t, err, k)
+	}
+	_, err = ParseRules(map[string]string{"cache.health.rules.y.severity": "info"}, "cache.health.rules.")
+	assert.Error(t, err, "Severity without rule")
+}
+
+func TestRule_Check(t *testing.T) {
+	he := NewHealthEndpoint([]string{"stats"})
+	r, err := NewRule("stats", "count(stats) >= 1", "")
+	assert.NoError(t, err)
+	res := r.Check(he)
+	assert.Equal(t, Unhealthy, res.Status)
+	assert.Equal(t, "rules.stats failed:(count(stats) >= 1)", res.Message)
+	assert.Equal(t, false, res.Details["result"])
+	he.AddRoutine("stats", rt1)
+	res = r.Check(he)
+	assert.Equal(t, Healthy, res.Status)
+	assert.Equal(t, "", res.Message)
+	r, _ = NewRule("vital", "age(v1) < 10", severityInfo)
+	res = r.Check(he)
+	assert.Equal(t, Healthy, res.Status, "Info rules do not change the status")
+	assert.Equal(t, "vital 'v1' not found", res.Details["error"])
+}
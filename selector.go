@@ -0,0 +1,167 @@
+package qcache_health
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"github.com/docker/docker/api/types"
+)
+
+// kvMatcher matches a label or environment variable, by key only if val is empty.
+type kvMatcher struct {
+	key 	string
+	val 	string
+}
+
+func (m kvMatcher) match(kv map[string]string) bool {
+	v, ok := kv[m.key]
+	return ok && (m.val == "" || m.val == v)
+}
+
+// parseMatchers parses a comma separated list of 'key' or 'key=value'.
+func parseMatchers(s string) []kvMatcher {
+	res := []kvMatcher{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		m := kvMatcher{key: kv[0]}
+		if len(kv) == 2 {
+			m.val = kv[1]
+		}
+		res = append(res, m)
+	}
+	return res
+}
+
+func matchAny(ms []kvMatcher, kv map[string]string) bool {
+	for _, m := range ms {
+		if m.match(kv) {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainerSelector decides which containers are considered by the health comparison.
+// Without include selectors all containers are included, a container matching any
+// exclude selector is left out.
+type ContainerSelector struct {
+	includeLabels 	[]kvMatcher
+	excludeLabels 	[]kvMatcher
+	includeEnv 		[]kvMatcher
+	excludeEnv 		[]kvMatcher
+	includeName 	*regexp.Regexp
+	excludeName 	*regexp.Regexp
+}
+
+// NewContainerSelector creates a selector from comma separated 'key[=value]' lists for labels and
+// environment variables and regular expressions for the container names; empty strings are ignored.
+func NewContainerSelector(includeLabels, excludeLabels, includeEnv, excludeEnv, includeName, excludeName string) (cs ContainerSelector, err error) {
+	cs = ContainerSelector{
+		includeLabels: parseMatchers(includeLabels),
+		excludeLabels: parseMatchers(excludeLabels),
+		includeEnv: parseMatchers(includeEnv),
+		excludeEnv: parseMatchers(excludeEnv),
+	}
+	if includeName != "" {
+		if cs.includeName, err = regexp.Compile(includeName); err != nil {
+			return cs, fmt.Errorf("Could not compile include-names '%s': %s", includeName, err)
+		}
+	}
+	if excludeName != "" {
+		if cs.excludeName, err = regexp.Compile(excludeName); err != nil {
+			return cs, fmt.Errorf("Could not compile exclude-names '%s': %s", excludeName, err)
+		}
+	}
+	return
+}
+
+func (cs ContainerSelector) hasIncludes() bool {
+	return len(cs.includeLabels) > 0 || len(cs.includeEnv) > 0 || cs.includeName != nil
+}
+
+// NeedsEnv reports whether the environment of the containers has to be inspected.
+func (cs ContainerSelector) NeedsEnv() bool {
+	return len(cs.includeEnv) > 0 || len(cs.excludeEnv) > 0
+}
+
+// Selects reports whether the container is considered by the health comparison.
+func (cs ContainerSelector) Selects(name string, labels, env map[string]string) bool {
+	if matchAny(cs.excludeLabels, labels) || matchAny(cs.excludeEnv, env) {
+		return false
+	}
+	if cs.excludeName != nil && cs.excludeName.MatchString(name) {
+		return false
+	}
+	if !cs.hasIncludes() {
+		return true
+	}
+	return matchAny(cs.includeLabels, labels) || matchAny(cs.includeEnv, env) ||
+		(cs.includeName != nil && cs.includeName.MatchString(name))
+}
+
+// parseEnv turns 'KEY=value' entries into a map.
+func parseEnv(env []string) map[string]string {
+	res := map[string]string{}
+	for _, e := range env {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) == 2 {
+			res[kv[0]] = kv[1]
+		} else {
+			res[kv[0]] = ""
+		}
+	}
+	return res
+}
+
+// SetExcludedContainers stores the running containers (ID -> name) left out by the selector,
+// their routines are neither counted nor reported.
+func (he *HealthEndpoint) SetExcludedContainers(cnts map[string]string) {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	he.excluded = map[string]string{}
+	for id, name := range cnts {
+		he.excluded[shortID(id)] = name
+	}
+}
+
+func (he *HealthEndpoint) getExcluded() []string {
+	res := []string{}
+	for id, name := range he.excluded {
+		if name != "" {
+			res = append(res, fmt.Sprintf("%s(%s)", name, id))
+		} else {
+			res = append(res, id)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// selectContainer applies the selector to a listed container, inspecting its environment if needed.
+func (p *Plugin) selectContainer(e *dockerEngine, cnt types.Container, name string) bool {
+	env := map[string]string{}
+	if p.selector.NeedsEnv() {
+		var ok bool
+		id := engineID(e.name, cnt.ID)
+		if env, ok = p.envCache[id]; !ok {
+			c, cancel := p.dockerCtx()
+			cjson, err := e.cli.ContainerInspect(c, cnt.ID)
+			cancel()
+			if err != nil {
+				p.Log("warn", fmt.Sprintf("Error during ContainerInspect(%s): %s", cnt.ID, err))
+				return true
+			}
+			env = map[string]string{}
+			if cjson.Config != nil {
+				env = parseEnv(cjson.Config.Env)
+			}
+			p.envCache[id] = env
+		}
+	}
+	return p.selector.Selects(name, cnt.Labels, env)
+}
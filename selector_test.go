@@ -0,0 +1,68 @@
+package qcache_health
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/docker/docker/api/types"
+)
+
+func TestContainerSelector_Selects(t *testing.T) {
+	cs, err := NewContainerSelector("", "", "", "", "", "")
+	assert.NoError(t, err)
+	assert.True(t, cs.Selects("web", nil, nil), "Everything is selected by default")
+	assert.False(t, cs.NeedsEnv())
+	cs, err = NewContainerSelector("", "org.qnib.infra=true", "", "LOG_CAPTURE_ENABLED=false", "", "^qframe-")
+	assert.NoError(t, err)
+	assert.True(t, cs.NeedsEnv())
+	assert.True(t, cs.Selects("web", map[string]string{"org.qnib.infra": "false"}, map[string]string{}))
+	assert.False(t, cs.Selects("web", map[string]string{"org.qnib.infra": "true"}, map[string]string{}))
+	assert.False(t, cs.Selects("web", nil, map[string]string{"LOG_CAPTURE_ENABLED": "false"}))
+	assert.False(t, cs.Selects("qframe-health", nil, nil))
+	cs, err = NewContainerSelector("app, tier=web", "", "", "", "^db", "")
+	assert.NoError(t, err)
+	assert.True(t, cs.Selects("x", map[string]string{"app": "shop"}, nil), "Key only matches any value")
+	assert.True(t, cs.Selects("x", map[string]string{"tier": "web"}, nil))
+	assert.False(t, cs.Selects("x", map[string]string{"tier": "db"}, nil))
+	assert.True(t, cs.Selects("db1", nil, nil))
+	_, err = NewContainerSelector("", "", "", "", "(", "")
+	assert.Error(t, err)
+}
+
+func TestParseEnv(t *testing.T) {
+	exp := map[string]string{"A": "1", "B": "x=y", "C": ""}
+	assert.Equal(t, exp, parseEnv([]string{"A=1", "B=x=y", "C"}))
+}
+
+func TestHealthEndpoint_ExcludedContainers(t *testing.T) {
+	he := NewHealthEndpoint([]string{"logSkip"})
+	he.SetRoutineGroups(map[string][]string{"logs": {"logSkip"}})
+	he.SetExcludedContainers(map[string]string{"aaaaaaaaaaaa0000": "collector"})
+	he.SetContainers(map[string]string{})
+	he.AddRoutine("logSkip", NewRoutine("aaaaaaaaaaaa", "start", ts))
+	assert.Equal(t, 0, he.CountRoutine("logSkip"))
+	assert.Equal(t, []string{}, he.GetDiscrepancies()["logs"].Orphaned)
+	assert.Equal(t, []string{"collector(aaaaaaaaaaaa)"}, he.GetJSON()["excluded"])
+	rr := he.Reconcile([]string{"aaaaaaaaaaaa0000"}, false, ts)
+	assert.Equal(t, []string{}, rr.Unrouted)
+}
+
+func TestPlugin_getRunningCntCountSelector(t *testing.T) {
+	srv := fakeDocker(t, []types.Container{
+		{ID: "aaaaaaaaaaaa0000", Names: []string{"/web"}},
+		{ID: "bbbbbbbbbbbb0000", Names: []string{"/qframe-health"}},
+		{ID: "cccccccccccc0000", Names: []string{"/db"}, Labels: map[string]string{"org.qnib.infra": "true"}},
+	})
+	defer srv.Close()
+	p := newDockerPlugin(t, srv, map[string]string{
+		"log.level": "error",
+		"cache.test.select.exclude-names": "^qframe-",
+		"cache.test.select.exclude-labels": "org.qnib.infra",
+	})
+	assert.Equal(t, 1, p.getRunningCntCount())
+	p.HealthEndpoint.AddRoutine("stats", NewRoutine("aaaaaaaaaaaa", "start", ts))
+	p.HealthEndpoint.AddRoutine("log", NewRoutine("aaaaaaaaaaaa", "start", ts))
+	p.HealthEndpoint.AddRoutine("logSkip", NewRoutine("bbbbbbbbbbbb", "start", ts))
+	p.checkHealth(1)
+	s, m := p.HealthEndpoint.CurrentHealth()
+	assert.Equal(t, Healthy, s, m)
+}
@@ -0,0 +1,117 @@
+package qcache_health
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// httpServer keeps track of the currently bound server, which is replaced on every rebind.
+type httpServer struct {
+	mu 		sync.Mutex
+	addr 	string
+	stop 	chan struct{}
+	stopped chan struct{}
+}
+
+// HTTPAddr returns the address the health endpoint is listening on, empty if it is not bound.
+func (p *Plugin) HTTPAddr() string {
+	p.httpSrv.mu.Lock()
+	defer p.httpSrv.mu.Unlock()
+	return p.httpSrv.addr
+}
+
+func (p *Plugin) setHTTPAddr(addr string) {
+	p.httpSrv.mu.Lock()
+	defer p.httpSrv.mu.Unlock()
+	p.httpSrv.addr = addr
+}
+
+// startHTTP binds the health endpoint in the background; it is rebound with backoff if the listener fails.
+func (p *Plugin) startHTTP() (err error) {
+	handler := p.httpHandler()
+	tlsCfg, err := p.tlsConfig()
+	if err != nil {
+		return
+	}
+	p.httpSrv.mu.Lock()
+	p.httpSrv.stop = make(chan struct{})
+	p.httpSrv.stopped = make(chan struct{})
+	stop, stopped := p.httpSrv.stop, p.httpSrv.stopped
+	p.httpSrv.mu.Unlock()
+	go p.serveHTTP(handler, tlsCfg, stop, stopped)
+	return
+}
+
+// stopHTTP shuts the server down, waiting for in-flight requests up to 'http-drain-timeout-ms'.
+func (p *Plugin) stopHTTP() {
+	p.httpSrv.mu.Lock()
+	stop, stopped := p.httpSrv.stop, p.httpSrv.stopped
+	p.httpSrv.stop = nil
+	p.httpSrv.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-stopped
+}
+
+func (p *Plugin) serveHTTP(handler http.Handler, tlsCfg *tls.Config, stop, stopped chan struct{}) {
+	defer close(stopped)
+	bindHost := p.CfgStringOr("bind-host", "0.0.0.0")
+	bindPort := p.CfgStringOr("bind-port", "8123")
+	bindAddr := fmt.Sprintf("%s:%s", bindHost, bindPort)
+	drain := time.Duration(p.CfgIntOr("http-drain-timeout-ms", 5000))*time.Millisecond
+	minBackoff := time.Duration(p.CfgIntOr("http-retry-ms", 500))*time.Millisecond
+	maxBackoff := time.Duration(p.CfgIntOr("http-retry-max-ms", 30000))*time.Millisecond
+	backoff := minBackoff
+	for {
+		ln, err := net.Listen("tcp", bindAddr)
+		if err == nil {
+			backoff = minBackoff
+			if tlsCfg != nil {
+				ln = tls.NewListener(ln, tlsCfg)
+				p.Log("info", fmt.Sprintf("Start health-endpoint: https://%s", ln.Addr()))
+			} else {
+				p.Log("info", fmt.Sprintf("Start health-endpoint: %s", ln.Addr()))
+			}
+			p.setHTTPAddr(ln.Addr().String())
+			srv := &http.Server{Handler: handler}
+			// The event streams never end on their own, Shutdown() would wait for them until the drain timeout.
+			srv.RegisterOnShutdown(p.HealthEndpoint.Events().CloseSubscribers)
+			errc := make(chan error, 1)
+			go func() {
+				errc <- srv.Serve(ln)
+			}()
+			select {
+			case <-stop:
+				p.Log("info", fmt.Sprintf("Shutdown health-endpoint, draining for up to %s", drain))
+				ctx, cancel := context.WithTimeout(context.Background(), drain)
+				if err := srv.Shutdown(ctx); err != nil {
+					p.Log("warn", fmt.Sprintf("Graceful shutdown failed: %v", err))
+					srv.Close()
+				}
+				cancel()
+				<-errc
+				p.setHTTPAddr("")
+				return
+			case err = <-errc:
+				p.setHTTPAddr("")
+			}
+		}
+		p.Log("error", fmt.Sprintf("Health-endpoint on '%s' failed, rebind in %s: %v", bindAddr, backoff, err))
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
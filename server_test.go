@@ -0,0 +1,90 @@
+package qcache_health
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+	"github.com/zpatrick/go-config"
+	"github.com/qframe/types/qchannel"
+)
+
+func waitFor(t *testing.T, cond func() bool) {
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Condition not met in time")
+}
+
+func TestPlugin_RunRestart(t *testing.T) {
+	cfgMap := map[string]string{
+		"log.level": "error",
+		"cache.test.bind-host": "127.0.0.1",
+		"cache.test.bind-port": "0",
+		"cache.test.docker-host": "tcp://127.0.0.1:1",
+	}
+	for i := 0; i < 3; i++ {
+		qchan := qtypes_qchannel.NewQChan()
+		qchan.Broadcast()
+		cfg := config.NewConfig([]config.Provider{config.NewStatic(cfgMap)})
+		p, err := New(qchan, cfg, "test")
+		assert.NoError(t, err)
+		res := make(chan error)
+		go func() {
+			res <- p.Run()
+		}()
+		waitFor(t, func() bool { return p.HTTPAddr() != "" })
+		addr := p.HTTPAddr()
+		resp, err := http.Get(fmt.Sprintf("http://%s/_health/live", addr))
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		qchan.Done.Send(true)
+		assert.NoError(t, <-res)
+		assert.Equal(t, "", p.HTTPAddr())
+		_, err = net.Dial("tcp", addr)
+		assert.Error(t, err, "Listener should be closed after Done")
+	}
+}
+
+func TestPlugin_serveHTTPRebind(t *testing.T) {
+	blocker, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	_, port, _ := net.SplitHostPort(blocker.Addr().String())
+	p := newCfgPlugin(t, map[string]string{
+		"log.level": "error",
+		"cache.test.bind-host": "127.0.0.1",
+		"cache.test.bind-port": port,
+		"cache.test.http-retry-ms": "10",
+	})
+	assert.NoError(t, p.startHTTP())
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "", p.HTTPAddr(), "Port is still taken")
+	blocker.Close()
+	waitFor(t, func() bool { return p.HTTPAddr() != "" })
+	p.stopHTTP()
+	assert.Equal(t, "", p.HTTPAddr())
+	p.stopHTTP()
+}
+
+func TestPlugin_stopHTTPEvents(t *testing.T) {
+	p := newCfgPlugin(t, map[string]string{
+		"log.level": "error",
+		"cache.test.bind-host": "127.0.0.1",
+		"cache.test.bind-port": "0",
+		"cache.test.http-drain-timeout-ms": "5000",
+	})
+	assert.NoError(t, p.startHTTP())
+	waitFor(t, func() bool { return p.HTTPAddr() != "" })
+	resp, err := http.Get(fmt.Sprintf("http://%s/_health/events", p.HTTPAddr()))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	start := time.Now()
+	p.stopHTTP()
+	assert.True(t, time.Since(start) < time.Second, "Open event streams do not hold up the shutdown")
+}
@@ -0,0 +1,62 @@
+package qcache_health
+
+import (
+	"time"
+)
+
+// SetMaxSilence sets how long routines of a type may go without a beat before they are stale.
+// A zero duration disables the staleness of the type.
+func (he *HealthEndpoint) SetMaxSilence(routineType string, d time.Duration) {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	if d <= 0 {
+		delete(he.maxSilence, routineType)
+		return
+	}
+	he.maxSilence[routineType] = d
+}
+
+// activeRoutines returns the IDs of the routines of a type, which are not stale at t.
+func (he *HealthEndpoint) activeRoutines(routineType string, t time.Time) []string {
+	active, _ := he.splitRoutines(routineType, t)
+	return active
+}
+
+// splitRoutines separates the IDs of the routines of a type into active and stale ones at t,
+// failed routines and those of excluded containers are neither.
+func (he *HealthEndpoint) splitRoutines(routineType string, t time.Time) (active, stale []string) {
+	active, stale = []string{}, []string{}
+	r, ok := he.goRoutines[routineType]
+	if !ok {
+		return
+	}
+	for _, id := range r.Get() {
+		rt, _ := r.GetRoutine(id)
+		if _, ok := he.excluded[id]; ok || rt.state == RoutineFailed {
+			continue
+		}
+		if rt.IsStale(t, he.maxSilence[routineType]) {
+			stale = append(stale, id)
+		} else {
+			active = append(active, id)
+		}
+	}
+	return
+}
+
+// GetStaleRoutines returns the IDs of stale routines per routine type, omitting types without.
+func (he *HealthEndpoint) GetStaleRoutines() map[string][]string {
+	he.mu.RLock()
+	defer he.mu.RUnlock()
+	return he.getStaleRoutines(time.Now())
+}
+
+func (he *HealthEndpoint) getStaleRoutines(t time.Time) map[string][]string {
+	res := map[string][]string{}
+	for typ := range he.maxSilence {
+		if _, stale := he.splitRoutines(typ, t); len(stale) > 0 {
+			res[typ] = stale
+		}
+	}
+	return res
+}
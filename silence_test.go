@@ -0,0 +1,46 @@
+package qcache_health
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"time"
+)
+
+func TestHealthEndpoint_StaleRoutines(t *testing.T) {
+	now := time.Now()
+	he := NewHealthEndpoint([]string{"log", "stats"})
+	he.SetRoutineGroups(map[string][]string{"logs": {"log"}})
+	he.SetContainers(map[string]string{"aaaaaaaaaaaa0000": "web"})
+	he.AddRoutine("log", NewRoutine("aaaaaaaaaaaa", "start", now.Add(-time.Minute)))
+	he.AddRoutine("log", NewRoutine("bbbbbbbbbbbb", "start", now.Add(-time.Minute)))
+	he.AddRoutine("stats", NewRoutine("aaaaaaaaaaaa", "start", now.Add(-time.Minute)))
+	assert.Equal(t, 2, he.CountRoutine("log"))
+	he.SetMaxSilence("log", 30*time.Second)
+	assert.Equal(t, 0, he.CountRoutine("log"))
+	assert.Equal(t, 1, he.CountRoutine("stats"), "Types without max silence never go stale")
+	assert.NoError(t, he.BeatRoutine("log", NewRoutine("aaaaaaaaaaaa", "beat", now)))
+	assert.Equal(t, 1, he.CountRoutine("log"))
+	assert.Equal(t, map[string][]string{"log": {"bbbbbbbbbbbb"}}, he.GetStaleRoutines())
+	j := he.GetJSON()
+	assert.Equal(t, "aaaaaaaaaaaa", j["routines"].(map[string]string)["log"])
+	assert.Equal(t, map[string][]string{"log": {"bbbbbbbbbbbb"}}, j["stale_routines"])
+	assert.True(t, strings.Contains(he.GetTXT(), "?log           : | 1  | bbbbbbbbbbbb\n"))
+	assert.Equal(t, []string{}, he.GetDiscrepancies()["logs"].Orphaned, "Stale routines do not count as orphans")
+	_, res := he.getRoutinesJSON([]string{"log", "bbbbbbbbbbbb"}, now)
+	assert.Equal(t, true, res.(map[string]interface{})["stale"])
+	he.SetMaxSilence("log", 0)
+	assert.Equal(t, 2, he.CountRoutine("log"))
+}
+
+func TestHealthEndpoint_BeatRoutine(t *testing.T) {
+	he := NewHealthEndpoint([]string{"log"})
+	assert.Error(t, he.BeatRoutine("stats", rt1))
+	assert.Error(t, he.BeatRoutine("log", rt1), "Routine was never started")
+	he.AddRoutine("log", NewRoutine("id1", "start", ts))
+	assert.NoError(t, he.BeatRoutine("log", NewRoutine("id1", "beat", ts.Add(time.Minute))))
+	rt, _ := he.goRoutines["log"].GetRoutine("id1")
+	assert.Equal(t, ts.Add(time.Minute), rt.GetLastBeatTime())
+	assert.Equal(t, ts.Add(time.Minute), rt.GetLastUpdateTime())
+	assert.Equal(t, "start", rt.GetStatus())
+}
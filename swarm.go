@@ -0,0 +1,271 @@
+package qcache_health
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/qframe/types/docker-events"
+)
+
+const (
+	defaultConvergeTimeout = time.Minute
+	defaultSwarmPoll = 10 * time.Second
+	defaultSwarmRetryMax = 5 * time.Minute
+)
+
+// swarmPoll spaces the ServiceList()/TaskList() calls by 'swarm.poll-ms', backing off while they fail.
+type swarmPoll struct {
+	next 	time.Time
+	backoff time.Duration
+	failing bool
+}
+
+// ServiceStatus compares the desired with the running tasks of a Swarm service.
+type ServiceStatus struct {
+	ID 			string
+	Name 		string
+	Desired 	int
+	Running 	int
+	UpdateState string
+	// Since is the time the service was first seen diverged, zero while converged
+	Since 		time.Time
+}
+
+func (ss ServiceStatus) Converged() bool {
+	return ss.Running == ss.Desired
+}
+
+// rolloutPaused reports whether an update or rollback was paused due to failing tasks.
+func (ss ServiceStatus) rolloutPaused() bool {
+	return ss.UpdateState == string(swarm.UpdateStatePaused) || ss.UpdateState == string(swarm.UpdateStateRollbackPaused)
+}
+
+func (ss ServiceStatus) String() string {
+	return fmt.Sprintf("%s(%d/%d)", ss.Name, ss.Running, ss.Desired)
+}
+
+func (ss ServiceStatus) GetJSON() map[string]interface{} {
+	res := map[string]interface{}{
+		"name": ss.Name,
+		"desired": ss.Desired,
+		"running": ss.Running,
+		"converged": ss.Converged(),
+		"update_state": ss.UpdateState,
+	}
+	if !ss.Since.IsZero() {
+		res["diverged_since"] = ss.Since.Format(time.RFC3339Nano)
+	}
+	return res
+}
+
+// EnableServices starts tracking Swarm services, which have to converge within timeout.
+func (he *HealthEndpoint) EnableServices(timeout time.Duration) {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	he.services = map[string]ServiceStatus{}
+	he.convergeTimeout = timeout
+}
+
+func (he *HealthEndpoint) ServicesEnabled() bool {
+	he.mu.RLock()
+	defer he.mu.RUnlock()
+	return he.services != nil
+}
+
+// SetServices replaces the tracked services, keeping the time since which a service diverges.
+func (he *HealthEndpoint) SetServices(svcs []ServiceStatus, t time.Time) {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	res := map[string]ServiceStatus{}
+	for _, ss := range svcs {
+		prev, ok := he.services[ss.ID]
+		switch {
+		case ss.Converged():
+			ss.Since = time.Time{}
+		case ok && !prev.Since.IsZero():
+			ss.Since = prev.Since
+		default:
+			ss.Since = t
+		}
+		res[ss.ID] = ss
+	}
+	he.services = res
+}
+
+// TouchService restarts the convergence timeout of a diverged service, e.g. after an update.
+func (he *HealthEndpoint) TouchService(id string, t time.Time) {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	if ss, ok := he.services[id]; ok && !ss.Converged() {
+		ss.Since = t
+		he.services[id] = ss
+	}
+}
+
+func (he *HealthEndpoint) DelService(id string) {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	delete(he.services, id)
+}
+
+// GetServices returns the tracked services per ID, nil if Swarm services are not tracked.
+func (he *HealthEndpoint) GetServices() map[string]ServiceStatus {
+	he.mu.RLock()
+	defer he.mu.RUnlock()
+	if he.services == nil {
+		return nil
+	}
+	res := map[string]ServiceStatus{}
+	for id, ss := range he.services {
+		res[id] = ss
+	}
+	return res
+}
+
+// getNonConverged returns the services diverged for longer than the convergence timeout
+// or with a paused rollout, as 'name(running/desired)'.
+func (he *HealthEndpoint) getNonConverged(t time.Time) []string {
+	res := []string{}
+	for _, ss := range he.services {
+		if ss.rolloutPaused() || (!ss.Converged() && t.Sub(ss.Since) >= he.convergeTimeout) {
+			res = append(res, ss.String())
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (he *HealthEndpoint) getServicesJSON() map[string]interface{} {
+	res := map[string]interface{}{}
+	for id, ss := range he.services {
+		res[id] = ss.GetJSON()
+	}
+	return res
+}
+
+func (he *HealthEndpoint) getServicesTXT() []string {
+	res := []string{}
+	ids := []string{}
+	for id := range he.services {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		ss := he.services[id]
+		res = append(res, fmt.Sprintf("%-15s: | %d/%d | %s", "@"+ss.Name, ss.Running, ss.Desired, ss.UpdateState))
+	}
+	return res
+}
+
+// NewSwarmChecker sets the status to severity while services did not converge.
+func NewSwarmChecker(severity string) Checker {
+	return NewCheckerFunc("services", func(he *HealthEndpoint) CheckResult {
+		he.mu.RLock()
+		nonConverged := he.getNonConverged(time.Now())
+		he.mu.RUnlock()
+		res := CheckResult{
+			Status: Healthy,
+			Details: map[string]interface{}{"not_converged": nonConverged},
+		}
+		if len(nonConverged) > 0 {
+			res.Status = severity
+			res.Message = fmt.Sprintf("services not converged:[%s]", strings.Join(nonConverged, ","))
+		}
+		return res
+	})
+}
+
+// updateServices polls the services of the Swarm every 'swarm.poll-ms'. Failures (e.g. on a
+// non-manager node) are logged once and retried with a backoff up to 'swarm.retry-max-ms'.
+func (p *Plugin) updateServices(t time.Time) {
+	if t.Before(p.swarm.next) {
+		return
+	}
+	poll := time.Duration(p.CfgIntOr("swarm.poll-ms", int(defaultSwarmPoll/time.Millisecond)))*time.Millisecond
+	err := p.fetchServices(t)
+	if err == nil {
+		if p.swarm.failing {
+			p.Log("info", "Swarm services are available again")
+		}
+		p.swarm = swarmPoll{next: t.Add(poll)}
+		return
+	}
+	maxBackoff := time.Duration(p.CfgIntOr("swarm.retry-max-ms", int(defaultSwarmRetryMax/time.Millisecond)))*time.Millisecond
+	switch {
+	case p.swarm.backoff < poll:
+		p.swarm.backoff = poll
+	case p.swarm.backoff*2 > maxBackoff:
+		p.swarm.backoff = maxBackoff
+	default:
+		p.swarm.backoff *= 2
+	}
+	level := "debug"
+	if !p.swarm.failing {
+		level = "error"
+	}
+	p.Log(level, fmt.Sprintf("%s (retry in %s)", err, p.swarm.backoff))
+	p.swarm.failing = true
+	p.swarm.next = t.Add(p.swarm.backoff)
+}
+
+// fetchServices fetches the services and the tasks desired to run from the first engine, which has to be
+// a manager. Replicated services desire their replicas; global services desire one running task per
+// eligible node, counted as the tasks with a desired state of running.
+func (p *Plugin) fetchServices(t time.Time) error {
+	e := p.engines[0]
+	if !e.conn.reachable {
+		return fmt.Errorf("Could not fetch services, engine unreachable")
+	}
+	c, cancel := p.dockerCtx()
+	defer cancel()
+	svcs, err := e.cli.ServiceList(c, types.ServiceListOptions{})
+	if err != nil {
+		return fmt.Errorf("Error during ServiceList(): %s", err)
+	}
+	running := filters.NewArgs()
+	running.Add("desired-state", string(swarm.TaskStateRunning))
+	tasks, err := e.cli.TaskList(c, types.TaskListOptions{Filters: running})
+	if err != nil {
+		return fmt.Errorf("Error during TaskList(): %s", err)
+	}
+	runningCnt := map[string]int{}
+	desired := map[string]int{}
+	for _, task := range tasks {
+		if task.DesiredState == swarm.TaskStateRunning {
+			desired[task.ServiceID]++
+		}
+		if task.Status.State == swarm.TaskStateRunning {
+			runningCnt[task.ServiceID]++
+		}
+	}
+	res := []ServiceStatus{}
+	for _, svc := range svcs {
+		ss := ServiceStatus{ID: svc.ID, Name: svc.Spec.Name, Running: runningCnt[svc.ID], Desired: desired[svc.ID]}
+		if m := svc.Spec.Mode.Replicated; m != nil && m.Replicas != nil {
+			ss.Desired = int(*m.Replicas)
+		}
+		if svc.UpdateStatus != nil {
+			ss.UpdateState = string(svc.UpdateStatus.State)
+		}
+		res = append(res, ss)
+	}
+	p.HealthEndpoint.SetServices(res, t)
+	return nil
+}
+
+func (p *Plugin) handleServiceEvent(se qtypes_docker_events.ServiceEvent) {
+	if !p.HealthEndpoint.ServicesEnabled() {
+		return
+	}
+	id := se.Event.Actor.ID
+	switch se.Event.Action {
+	case "remove":
+		p.HealthEndpoint.DelService(id)
+	case "update":
+		p.HealthEndpoint.TouchService(id, se.Time)
+	}
+}
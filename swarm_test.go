@@ -0,0 +1,135 @@
+package qcache_health
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/events"
+	"github.com/qframe/types/docker-events"
+	"github.com/qframe/types/messages"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+// fakeSwarm serves the services and tasks of a swarm manager.
+func fakeSwarm(t *testing.T, svcs []swarm.Service, tasks []swarm.Task) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fakePing(w, r) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case fmt.Sprintf("/%s/services", dockerAPI):
+			json.NewEncoder(w).Encode(svcs)
+		case fmt.Sprintf("/%s/tasks", dockerAPI):
+			f, _ := filters.FromParam(r.URL.Query().Get("filters"))
+			res := []swarm.Task{}
+			for _, task := range tasks {
+				if f.Include("desired-state") && !f.ExactMatch("desired-state", string(task.DesiredState)) {
+					continue
+				}
+				res = append(res, task)
+			}
+			json.NewEncoder(w).Encode(res)
+		default:
+			t.Logf("fakeSwarm: unexpected request %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestHealthEndpoint_SetServices(t *testing.T) {
+	he := NewHealthEndpoint([]string{})
+	assert.False(t, he.ServicesEnabled())
+	assert.NotContains(t, he.GetJSON(), "services")
+	he.EnableServices(time.Minute)
+	he.SetServices([]ServiceStatus{{ID: "s1", Name: "web", Desired: 3, Running: 1}, {ID: "s2", Name: "db", Desired: 1, Running: 1}}, ts)
+	he.SetServices([]ServiceStatus{{ID: "s1", Name: "web", Desired: 3, Running: 2}, {ID: "s2", Name: "db", Desired: 1, Running: 1}}, ts.Add(30*time.Second))
+	svcs := he.GetServices()
+	assert.Equal(t, ts, svcs["s1"].Since, "Diverged since the first poll")
+	assert.True(t, svcs["s2"].Since.IsZero())
+	assert.Equal(t, []string{}, he.getNonConverged(ts.Add(59*time.Second)))
+	assert.Equal(t, []string{"web(2/3)"}, he.getNonConverged(ts.Add(time.Minute)))
+	he.TouchService("s1", ts.Add(time.Minute))
+	assert.Equal(t, []string{}, he.getNonConverged(ts.Add(time.Minute)), "An update restarts the timeout")
+	he.SetServices([]ServiceStatus{{ID: "s2", Name: "db", Desired: 1, Running: 1, UpdateState: "paused"}}, ts.Add(time.Minute))
+	assert.Equal(t, []string{"db(1/1)"}, he.getNonConverged(ts.Add(time.Minute)), "Paused rollouts fail right away")
+	assert.Contains(t, he.GetJSON(), "services")
+	assert.True(t, strings.Contains(he.GetTXT(), "@db            : | 1/1 | paused\n"))
+	he.DelService("s2")
+	assert.Len(t, he.GetServices(), 0)
+}
+
+func TestSwarmChecker(t *testing.T) {
+	he := NewHealthEndpoint([]string{})
+	he.EnableServices(0)
+	c := NewSwarmChecker(Unhealthy)
+	assert.Equal(t, Healthy, c.Check(he).Status)
+	he.SetServices([]ServiceStatus{{ID: "s1", Name: "web", Desired: 3, Running: 1}}, time.Now())
+	res := c.Check(he)
+	assert.Equal(t, Unhealthy, res.Status)
+	assert.Equal(t, "services not converged:[web(1/3)]", res.Message)
+}
+
+func TestPlugin_updateServices(t *testing.T) {
+	replicas := uint64(2)
+	svcs := []swarm.Service{
+		{ID: "s1", Spec: swarm.ServiceSpec{Annotations: swarm.Annotations{Name: "web"}, Mode: swarm.ServiceMode{Replicated: &swarm.ReplicatedService{Replicas: &replicas}}}},
+		{ID: "s2", Spec: swarm.ServiceSpec{Annotations: swarm.Annotations{Name: "agent"}, Mode: swarm.ServiceMode{Global: &swarm.GlobalService{}}}},
+	}
+	running := swarm.TaskStatus{State: swarm.TaskStateRunning}
+	tasks := []swarm.Task{
+		{ServiceID: "s1", DesiredState: swarm.TaskStateRunning, Status: running},
+		{ServiceID: "s2", DesiredState: swarm.TaskStateRunning, Status: running},
+		{ServiceID: "s2", DesiredState: swarm.TaskStateRunning, Status: swarm.TaskStatus{State: swarm.TaskStatePending}},
+		{ServiceID: "s2", DesiredState: swarm.TaskStateShutdown, Status: swarm.TaskStatus{State: swarm.TaskStateShutdown}},
+	}
+	srv := fakeSwarm(t, svcs, tasks)
+	defer srv.Close()
+	p := newDockerPlugin(t, srv, map[string]string{"log.level": "error", "cache.test.swarm.enabled": "true"})
+	assert.Contains(t, p.HealthEndpoint.Checkers(), "services")
+	p.updateServices(time.Now())
+	got := p.HealthEndpoint.GetServices()
+	assert.Equal(t, 2, got["s1"].Desired)
+	assert.Equal(t, 1, got["s1"].Running)
+	assert.Equal(t, 2, got["s2"].Desired)
+	assert.Equal(t, 1, got["s2"].Running)
+	de := qtypes_docker_events.NewDockerEvent(qtypes_messages.NewBase("base"), events.Message{Type: "service", Action: "remove", Actor: events.Actor{ID: "s1"}})
+	p.handleServiceEvent(qtypes_docker_events.NewServiceEvent(de, swarm.Service{}))
+	assert.NotContains(t, p.HealthEndpoint.GetServices(), "s1")
+}
+
+func TestPlugin_updateServicesPoll(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fakePing(w, r) {
+			return
+		}
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, `{"message": "This node is not a swarm manager."}`)
+	}))
+	defer srv.Close()
+	p := newDockerPlugin(t, srv, map[string]string{
+		"log.level": "error",
+		"cache.test.swarm.enabled": "true",
+		"cache.test.swarm.poll-ms": "1000",
+		"cache.test.swarm.retry-max-ms": "3000",
+	})
+	now := time.Now()
+	p.updateServices(now)
+	assert.Equal(t, 1, calls)
+	assert.True(t, p.swarm.failing)
+	p.updateServices(now.Add(500*time.Millisecond))
+	assert.Equal(t, 1, calls, "Not polled before swarm.poll-ms")
+	for i, exp := range []time.Duration{2000, 3000, 3000} {
+		p.updateServices(p.swarm.next)
+		assert.Equal(t, i+2, calls)
+		assert.Equal(t, exp*time.Millisecond, p.swarm.backoff)
+	}
+}
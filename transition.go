@@ -0,0 +1,106 @@
+package qcache_health
+
+import (
+	"fmt"
+	"time"
+)
+
+// TransitionPolicy decides whether a new evaluation changes the reported status, to suppress
+// flapping (e.g. of containers in a crash loop). The zero value keeps the ring-capacity based
+// behaviour of SetHealth.
+type TransitionPolicy struct {
+	// UnhealthyAfter evaluations out of the last UnhealthyWindow have to be unhealthy to become unhealthy.
+	UnhealthyAfter 	int
+	UnhealthyWindow int
+	// RecoverAfter consecutive evaluations have to be better than unhealthy to leave unhealthy.
+	RecoverAfter 	int
+	// MinDwell is the minimal time a status is kept before it may change.
+	MinDwell 		time.Duration
+}
+
+func (tp TransitionPolicy) Enabled() bool {
+	return tp.UnhealthyAfter > 0 || tp.RecoverAfter > 0 || tp.MinDwell > 0
+}
+
+func (tp TransitionPolicy) unhealthyWindow() int {
+	if tp.UnhealthyWindow < tp.UnhealthyAfter {
+		return tp.UnhealthyAfter
+	}
+	return tp.UnhealthyWindow
+}
+
+// window is the number of evaluations that have to be kept.
+func (tp TransitionPolicy) window() int {
+	w := tp.unhealthyWindow()
+	if w < tp.RecoverAfter {
+		w = tp.RecoverAfter
+	}
+	return w
+}
+
+// errTransitionSuppressed is returned by SetHealth if the policy holds back a change, which is
+// the policy working as intended rather than a failure.
+type errTransitionSuppressed struct {
+	reason 	string
+}
+
+func (e errTransitionSuppressed) Error() string {
+	return e.reason
+}
+
+func isTransitionSuppressed(err error) bool {
+	_, ok := err.(errTransitionSuppressed)
+	return ok
+}
+
+// SetTransitionPolicy sets the policy applied by SetHealth.
+func (he *HealthEndpoint) SetTransitionPolicy(tp TransitionPolicy) {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	he.policy = tp
+	he.evals = []string{}
+}
+
+// checkTransition records the evaluation and returns an errTransitionSuppressed if the policy suppresses the change from prev to status.
+func (he *HealthEndpoint) checkTransition(prev, status string, t time.Time) error {
+	tp := he.policy
+	he.evals = append(he.evals, status)
+	if w := tp.window(); len(he.evals) > w {
+		he.evals = he.evals[len(he.evals)-w:]
+	}
+	if prev == status {
+		return nil
+	}
+	if dwell := t.Sub(he.changedAt); tp.MinDwell > 0 && dwell < tp.MinDwell {
+		return errTransitionSuppressed{fmt.Sprintf("Status '%s' kept for the minimum dwell time of %s (since %s)", prev, tp.MinDwell, dwell)}
+	}
+	if status == Unhealthy && tp.UnhealthyAfter > 0 {
+		window := tp.unhealthyWindow()
+		cnt := 0
+		for _, s := range lastN(he.evals, window) {
+			if s == Unhealthy {
+				cnt++
+			}
+		}
+		if cnt < tp.UnhealthyAfter {
+			return errTransitionSuppressed{fmt.Sprintf("Status '%s' kept, %d of the last %d evaluations unhealthy (need %d)", prev, cnt, window, tp.UnhealthyAfter)}
+		}
+	}
+	if prev == Unhealthy && tp.RecoverAfter > 0 {
+		cnt := 0
+		for i := len(he.evals) - 1; i >= 0 && he.evals[i] != Unhealthy; i-- {
+			cnt++
+		}
+		if cnt < tp.RecoverAfter {
+			return errTransitionSuppressed{fmt.Sprintf("Status '%s' kept, %d consecutive evaluations better than unhealthy (need %d)", prev, cnt, tp.RecoverAfter)}
+		}
+	}
+	return nil
+}
+
+func lastN(s []string, n int) []string {
+	if len(s) > n {
+		return s[len(s)-n:]
+	}
+	return s
+}
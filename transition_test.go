@@ -0,0 +1,44 @@
+package qcache_health
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"time"
+)
+
+func TestHealthEndpoint_TransitionNofM(t *testing.T) {
+	he := NewHealthEndpoint([]string{})
+	he.SetTransitionPolicy(TransitionPolicy{UnhealthyAfter: 3, UnhealthyWindow: 5, RecoverAfter: 2})
+	assert.NoError(t, he.setHealth(Healthy, "ok", ts))
+	// crash loop: alternating evaluations
+	assert.Error(t, he.setHealth(Unhealthy, "down", ts))
+	assert.NoError(t, he.setHealth(Healthy, "ok", ts))
+	assert.Error(t, he.setHealth(Unhealthy, "down", ts))
+	assert.NoError(t, he.setHealth(Healthy, "ok", ts))
+	s, _ := he.CurrentHealth()
+	assert.Equal(t, Healthy, s, "2 of the last 5 are unhealthy")
+	err := he.setHealth(Unhealthy, "down", ts)
+	assert.NoError(t, err, "3 of the last 5 are unhealthy")
+	s, m := he.CurrentHealth()
+	assert.Equal(t, Unhealthy, s)
+	assert.Equal(t, "down", m)
+	err = he.setHealth(Healthy, "ok", ts)
+	assert.Equal(t, "Status 'unhealthy' kept, 1 consecutive evaluations better than unhealthy (need 2)", err.Error())
+	assert.True(t, isTransitionSuppressed(err))
+	assert.NoError(t, he.setHealth(Healthy, "ok", ts))
+	s, _ = he.CurrentHealth()
+	assert.Equal(t, Healthy, s)
+}
+
+func TestHealthEndpoint_TransitionMinDwell(t *testing.T) {
+	he := NewHealthEndpoint([]string{})
+	he.SetTransitionPolicy(TransitionPolicy{MinDwell: time.Minute})
+	he.changedAt = ts
+	assert.Error(t, he.setHealth(Healthy, "ok", ts.Add(30*time.Second)))
+	assert.NoError(t, he.setHealth(Healthy, "ok", ts.Add(time.Minute)))
+	assert.NoError(t, he.setHealth(Healthy, "still ok", ts.Add(time.Minute+time.Second)), "Same status is always accepted")
+	assert.Error(t, he.setHealth(Unhealthy, "down", ts.Add(90*time.Second)))
+	assert.NoError(t, he.setHealth(Unhealthy, "down", ts.Add(2*time.Minute)))
+	s, _ := he.CurrentHealth()
+	assert.Equal(t, Unhealthy, s, "Unhealthy right away without N-of-M")
+}
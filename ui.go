@@ -0,0 +1,106 @@
+package qcache_health
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const (
+	uiRefreshMs = 2500
+)
+
+// uiPage is a self-contained dashboard, polling the JSON endpoints of the same server.
+const uiPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>qframe health</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; color: #222; }
+h1 span { padding: 0.1em 0.5em; border-radius: 4px; color: #fff; }
+.healthy { background: #2e7d32; }
+.unhealthy { background: #c62828; }
+.starting, .degraded { background: #ef6c00; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { text-align: left; padding: 0.2em 0.8em; border-bottom: 1px solid #ddd; font-size: 0.9em; }
+td.id { font-family: monospace; }
+#updated { color: #888; font-size: 0.8em; }
+</style>
+</head>
+<body>
+<h1>Health: <span id="status">loading</span></h1>
+<p id="message"></p>
+<h2>History</h2>
+<table id="history"><thead><tr><th>time</th><th>status</th><th>message</th></tr></thead><tbody></tbody></table>
+<h2>Routines</h2>
+<div id="routines"></div>
+<h2>Vitals</h2>
+<table id="vitals"><thead><tr><th>name</th><th>status</th><th>time_ago</th><th>time_updated</th></tr></thead><tbody></tbody></table>
+<p id="updated"></p>
+<script>
+(function() {
+  var refreshMs = %d;
+  function el(tag, text, cls) {
+    var e = document.createElement(tag);
+    if (text !== undefined) { e.textContent = text; }
+    if (cls) { e.className = cls; }
+    return e;
+  }
+  function row(tbody, cells) {
+    var tr = el("tr");
+    cells.forEach(function(c) { tr.appendChild(c instanceof Node ? c : el("td", c)); });
+    tbody.appendChild(tr);
+  }
+  function get(url) {
+    return fetch(url, {headers: {"Accept": "application/json"}, credentials: "same-origin"}).then(function(r) { return r.json(); });
+  }
+  function render(health, history, routines) {
+    var s = document.getElementById("status");
+    s.textContent = health.status;
+    s.className = health.status;
+    document.getElementById("message").textContent = health.message;
+    var hb = document.querySelector("#history tbody");
+    hb.innerHTML = "";
+    history.slice().reverse().forEach(function(e) {
+      row(hb, [e.time, el("td", e.status, e.status), e.message]);
+    });
+    var rd = document.getElementById("routines");
+    rd.innerHTML = "";
+    Object.keys(routines).sort().forEach(function(typ) {
+      rd.appendChild(el("h3", typ + " (" + routines[typ].length + ")"));
+      var t = el("table");
+      var tb = el("tbody");
+      row(tb, [el("th", "id"), el("th", "status"), el("th", "age"), el("th", "since_update")]);
+      routines[typ].forEach(function(r) {
+        row(tb, [el("td", r.id, "id"), r.status, r.age, r.since_update]);
+      });
+      t.appendChild(tb);
+      rd.appendChild(t);
+    });
+    var vb = document.querySelector("#vitals tbody");
+    vb.innerHTML = "";
+    var vitals = health.vitals || {};
+    Object.keys(vitals).sort().forEach(function(n) {
+      var v = vitals[n];
+      row(vb, [n, v.status, v.time_ago, v.time_updated]);
+    });
+    document.getElementById("updated").textContent = "Updated " + new Date().toISOString();
+  }
+  function refresh() {
+    Promise.all([get("/_health"), get("/_health/history?limit=20"), get("/_health/routines")])
+      .then(function(res) { render(res[0], res[1], res[2]); })
+      .catch(function(err) { document.getElementById("updated").textContent = "Update failed: " + err; })
+      .then(function() { setTimeout(refresh, refreshMs); });
+  }
+  refresh();
+})();
+</script>
+</body>
+</html>
+`
+
+// HandleUI serves the HTML dashboard.
+func (he *HealthEndpoint) HandleUI(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, uiPage, uiRefreshMs)
+}
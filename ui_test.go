@@ -0,0 +1,21 @@
+package qcache_health
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+func TestHealthEndpoint_HandleUI(t *testing.T) {
+	he := NewHealthEndpoint([]string{})
+	rec := httptest.NewRecorder()
+	he.HandleUI(rec, httptest.NewRequest("GET", "/_health/ui", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	body := rec.Body.String()
+	assert.Contains(t, body, "var refreshMs = 2500;")
+	assert.False(t, strings.Contains(body, "%!"), "Template should be formatted cleanly")
+	assert.False(t, strings.Contains(body, "src=\"http"), "No external assets")
+}
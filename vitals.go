@@ -1,6 +1,11 @@
 package qcache_health
 
 import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -36,4 +41,151 @@ func (v *Vitals) getJSON(t time.Time) map[string]interface{} {
 		"time_updated": v.LastSign.Format(time.RFC3339Nano),
 		"time_ago": t.Sub(v.LastSign).String(),
 	}
-}
\ No newline at end of file
+}
+
+const (
+	vitalWarn = "warn"
+)
+
+// VitalPolicy sets the ages at which vitals matching Pattern (a path.Match glob) are
+// considered stale (Warn, Unhealthy) and removed (Expire). Zero disables a threshold.
+type VitalPolicy struct {
+	Pattern 	string
+	Warn 		time.Duration
+	Unhealthy 	time.Duration
+	Expire 		time.Duration
+}
+
+// Level returns the staleness level for the given age: "", "warn" or "unhealthy".
+func (vp VitalPolicy) Level(age time.Duration) string {
+	switch {
+	case vp.Unhealthy > 0 && age > vp.Unhealthy:
+		return Unhealthy
+	case vp.Warn > 0 && age > vp.Warn:
+		return vitalWarn
+	}
+	return ""
+}
+
+// ParseVitalPolicies collects the policies from the flat configuration map below prefix:
+//
+//	<prefix><pattern>.warn-age-ms
+//	<prefix><pattern>.unhealthy-age-ms
+//	<prefix><pattern>.expire-age-ms
+//
+// Exact names are matched before patterns, longer patterns before shorter ones.
+func ParseVitalPolicies(cfg map[string]string, prefix string) (res []VitalPolicy, err error) {
+	policies := map[string]*VitalPolicy{}
+	for k, v := range cfg {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		key := strings.TrimPrefix(k, prefix)
+		idx := strings.LastIndex(key, ".")
+		if idx < 0 {
+			return nil, fmt.Errorf("unknown vitals setting '%s'", k)
+		}
+		pattern, setting := key[:idx], key[idx+1:]
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid vitals pattern '%s': %v", pattern, err)
+		}
+		ms, err := strconv.Atoi(v)
+		if err != nil || ms < 0 {
+			return nil, fmt.Errorf("'%s' has to be a positive number of milliseconds, got '%s'", k, v)
+		}
+		if _, ok := policies[pattern]; !ok {
+			policies[pattern] = &VitalPolicy{Pattern: pattern}
+		}
+		d := time.Duration(ms)*time.Millisecond
+		switch setting {
+		case "warn-age-ms":
+			policies[pattern].Warn = d
+		case "unhealthy-age-ms":
+			policies[pattern].Unhealthy = d
+		case "expire-age-ms":
+			policies[pattern].Expire = d
+		default:
+			return nil, fmt.Errorf("unknown vitals setting '%s'", k)
+		}
+	}
+	for _, vp := range policies {
+		res = append(res, *vp)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		gi, gj := isGlob(res[i].Pattern), isGlob(res[j].Pattern)
+		if gi != gj {
+			return !gi
+		}
+		if len(res[i].Pattern) != len(res[j].Pattern) {
+			return len(res[i].Pattern) > len(res[j].Pattern)
+		}
+		return res[i].Pattern < res[j].Pattern
+	})
+	return
+}
+
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// SetVitalPolicies sets the staleness policies, ordered by precedence.
+func (he *HealthEndpoint) SetVitalPolicies(policies []VitalPolicy) {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	he.vitalPolicies = policies
+}
+
+func (he *HealthEndpoint) vitalPolicy(name string) (VitalPolicy, bool) {
+	for _, vp := range he.vitalPolicies {
+		if ok, _ := path.Match(vp.Pattern, name); ok {
+			return vp, true
+		}
+	}
+	return VitalPolicy{}, false
+}
+
+// vitalLevel returns the staleness level of a vital at time t.
+func (he *HealthEndpoint) vitalLevel(name string, t time.Time) string {
+	vp, ok := he.vitalPolicy(name)
+	if !ok {
+		return ""
+	}
+	return vp.Level(t.Sub(he.vitals[name].LastSign))
+}
+
+// GetStaleVitals returns the names of the vitals per staleness level at time t.
+func (he *HealthEndpoint) GetStaleVitals(t time.Time) map[string][]string {
+	he.mu.RLock()
+	defer he.mu.RUnlock()
+	res := map[string][]string{}
+	for n := range he.vitals {
+		if l := he.vitalLevel(n, t); l != "" {
+			res[l] = append(res[l], n)
+		}
+	}
+	for _, names := range res {
+		sort.Strings(names)
+	}
+	return res
+}
+
+// ExpireVitals removes the vitals older than the expire age of their policy.
+func (he *HealthEndpoint) ExpireVitals(t time.Time) (expired []string) {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	for n, v := range he.vitals {
+		vp, ok := he.vitalPolicy(n)
+		if !ok || vp.Expire <= 0 || t.Sub(v.LastSign) <= vp.Expire {
+			continue
+		}
+		delete(he.vitals, n)
+		expired = append(expired, n)
+		he.events.Publish("vitals", t, map[string]interface{}{
+			"name": n,
+			"status": "expired",
+			"previous": v.LastState,
+		})
+	}
+	sort.Strings(expired)
+	return
+}
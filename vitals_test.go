@@ -29,3 +29,65 @@ func TestVitals_GetJSON(t *testing.T) {
 	}
 	assert.Equal(t, exp, v.getJSON(t2h))
 }
+
+func TestParseVitalPolicies(t *testing.T) {
+	cfg := map[string]string{
+		"cache.health.vitals.*.unhealthy-age-ms": "600000",
+		"cache.health.vitals.docker-*.warn-age-ms": "60000",
+		"cache.health.vitals.docker-logs.warn-age-ms": "30000",
+		"cache.health.vitals.docker-logs.unhealthy-age-ms": "120000",
+		"cache.health.vitals.docker-logs.expire-age-ms": "3600000",
+	}
+	got, err := ParseVitalPolicies(cfg, "cache.health.vitals.")
+	assert.NoError(t, err)
+	exp := []VitalPolicy{
+		{Pattern: "docker-logs", Warn: 30*time.Second, Unhealthy: 2*time.Minute, Expire: time.Hour},
+		{Pattern: "docker-*", Warn: time.Minute},
+		{Pattern: "*", Unhealthy: 10*time.Minute},
+	}
+	assert.Equal(t, exp, got)
+	for k, v := range map[string]string{
+		"cache.health.vitals.x.warn-age-ms": "soon",
+		"cache.health.vitals.x.sleep-ms": "1",
+		"cache.health.vitals.[.warn-age-ms": "1",
+		"cache.health.vitals.x": "1",
+	} {
+		_, err = ParseVitalPolicies(map[string]string{k: v}, "cache.health.vitals.")
+		assert.Error(t, err, k)
+	}
+}
+
+func TestHealthEndpoint_StaleVitals(t *testing.T) {
+	he := NewHealthEndpoint([]string{})
+	he.SetVitalPolicies([]VitalPolicy{
+		{Pattern: "docker-logs", Warn: time.Minute, Unhealthy: 5*time.Minute, Expire: time.Hour},
+		{Pattern: "*", Warn: time.Minute},
+	})
+	he.UpsertVitals("docker-logs", "running", ts)
+	he.UpsertVitals("docker-stats", "running", ts.Add(3*time.Minute))
+	assert.Equal(t, map[string][]string{"warn": {"docker-logs"}}, he.GetStaleVitals(ts.Add(2*time.Minute)))
+	assert.Equal(t, map[string][]string{"unhealthy": {"docker-logs"}, "warn": {"docker-stats"}}, he.GetStaleVitals(ts.Add(10*time.Minute)))
+	assert.Equal(t, "unhealthy", he.getJSON(ts.Add(10*time.Minute))["vitals"].(map[string]interface{})["docker-logs"].(map[string]interface{})["stale"])
+	assert.Equal(t, []string(nil), he.ExpireVitals(ts.Add(time.Hour)))
+	assert.Equal(t, []string{"docker-logs"}, he.ExpireVitals(ts.Add(2*time.Hour)), "Only docker-logs has an expiry")
+	_, ok := he.GetVital("docker-logs")
+	assert.False(t, ok)
+	_, ok = he.GetVital("docker-stats")
+	assert.True(t, ok)
+}
+
+func TestVitalsChecker(t *testing.T) {
+	he := NewHealthEndpoint([]string{})
+	he.SetVitalPolicies([]VitalPolicy{{Pattern: "*", Warn: time.Minute, Unhealthy: time.Hour}})
+	c := NewVitalsChecker()
+	he.UpsertVitals("fresh", "running", time.Now())
+	assert.Equal(t, Healthy, c.Check(he).Status)
+	he.UpsertVitals("old", "running", time.Now().Add(-2*time.Minute))
+	res := c.Check(he)
+	assert.Equal(t, Degraded, res.Status, "Stale but not dead")
+	assert.Equal(t, "vitals warn:[old]", res.Message)
+	he.UpsertVitals("dead", "running", time.Now().Add(-2*time.Hour))
+	res = c.Check(he)
+	assert.Equal(t, Unhealthy, res.Status)
+	assert.Equal(t, "vitals unhealthy:[dead] | vitals warn:[old]", res.Message)
+}